@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+)
+
+// Limits of the archives uploaded for batch extraction.
+const (
+	maxArchiveEntries = 10000
+	maxExtractedSize  = 4 << 30
+)
+
+var (
+	errInvalidArchive     = errors.New("invalid archive")
+	errUnsupportedArchive = errors.New("unsupported archive, expected zip or tar")
+	errArchiveTooLarge    = fmt.Errorf("archive exceeds %d files or %d bytes", maxArchiveEntries, maxExtractedSize)
+)
+
+// archiveExtractor unpacks archive entries into a directory. The entries are
+// stored under generated names, so the archive paths cannot escape it.
+type archiveExtractor struct {
+	dir   string
+	files []upload
+	size  int64
+}
+
+func (e *archiveExtractor) add(name string, content io.Reader) error {
+	if len(e.files) == maxArchiveEntries {
+		return errArchiveTooLarge
+	}
+	target := filepath.Join(e.dir, strconv.Itoa(len(e.files))+path.Ext(name))
+	file, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(file, io.LimitReader(content, maxExtractedSize-e.size+1))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	e.size += written
+	if e.size > maxExtractedSize {
+		return errArchiveTooLarge
+	}
+	e.files = append(e.files, upload{name: name, path: target, size: written})
+	return nil
+}
+
+func (e *archiveExtractor) extractZip(content io.ReaderAt, size int64) error {
+	reader, err := zip.NewReader(content, size)
+	if err != nil {
+		return err
+	}
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = e.add(entry.Name, content)
+		closeReader(content)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *archiveExtractor) extractTar(content io.Reader) error {
+	reader := tar.NewReader(content)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		err = e.add(header.Name, reader)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// extractArchive unpacks the zip, tar or gzip compressed tar archive into the
+// directory, recognizing the format by its content.
+func extractArchive(archive upload, dir string) ([]upload, error) {
+	extractor := &archiveExtractor{dir: dir}
+
+	file, err := os.Open(archive.path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeReader(file)
+	}()
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(512)
+
+	switch {
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")) || bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		err = extractor.extractZip(file, archive.size)
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		var decompressed *gzip.Reader
+		decompressed, err = gzip.NewReader(buffered)
+		if err == nil {
+			err = extractor.extractTar(decompressed)
+		}
+	case len(magic) >= 262 && string(magic[257:262]) == "ustar":
+		err = extractor.extractTar(buffered)
+	default:
+		err = errUnsupportedArchive
+	}
+	if err != nil {
+		return nil, err
+	}
+	return extractor.files, nil
+}
+
+// extractArchiveMetadata extracts the metadata of every file in the archive
+// with a single exiftool run. Each result names the path of the file in the
+// archive as its source file. Files with the fingerprint of a previous file
+// are flagged as its duplicates. If progress is not nil, it is called with
+// every result as soon as it is extracted, before the files are grouped into
+// bursts, which needs all of them.
+func extractArchiveMetadata(ctx context.Context, options extractionOptions, archive upload, progress func(processed int, total int, metadata Metadata)) ([]Metadata, error) {
+	dir, err := ioutil.TempDir("", "exiftool2json-")
+	if err != nil {
+		return nil, fmt.Errorf("error creating temporary directory: %w", err)
+	}
+	defer func() {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			log.Printf("Error removing temporary directory: %v\n", err)
+		}
+	}()
+
+	files, err := extractArchive(archive, dir)
+	if errors.Is(err, errArchiveTooLarge) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
+	}
+	if len(files) == 0 {
+		return make([]Metadata, 0), nil
+	}
+
+	paths := make([]string, len(files))
+	names := make(map[string]string, len(files))
+	for i, file := range files {
+		paths[i] = file.path
+		names[file.path] = file.name
+	}
+	metadata := make([]Metadata, 0, len(files))
+	duplicates := make(duplicateDetector)
+	err = streamMetadata(ctx, options, nil, func(m Metadata) error {
+		if source, ok := m["SourceFile"].(string); ok {
+			err := addFileChecksums(m, options, source)
+			if err != nil {
+				return err
+			}
+			m["SourceFile"] = names[source]
+		}
+		duplicates.check(m)
+		metadata = append(metadata, m)
+		if progress != nil {
+			progress(len(metadata), len(files), m)
+		}
+		return nil
+	}, paths...)
+	if err != nil {
+		return nil, err
+	}
+	if options.burstWindow > 0 {
+		groupBursts(metadata, options.burstWindow)
+	}
+	return metadata, nil
+}
+
+// handleBatchMetadata extracts the metadata of every file in an uploaded zip
+// or tar archive.
+func handleBatchMetadata(ctx context.Context, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+
+		archive, err := saveUpload(r)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer archive.remove()
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		metadata, err := extractArchiveMetadata(commandCtx, options, archive, nil)
+		switch {
+		case errors.Is(err, errInvalidArchive):
+			writeExtractionError(w, r, http.StatusBadRequest, err)
+			return
+		case err != nil:
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, metadata)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// azureVersion is the version of the Blob service REST API requested.
+const azureVersion = "2020-10-02"
+
+// azureContainerPattern matches valid Azure Blob container names.
+var azureContainerPattern = regexp.MustCompile(`^[a-z0-9](-?[a-z0-9])+$`)
+
+// azureClient reads blobs from Azure Blob Storage of the account given by
+// AZURE_STORAGE_ACCOUNT. Requests are authorized with the Shared Key in
+// AZURE_STORAGE_KEY, else the SAS token in AZURE_STORAGE_SAS_TOKEN, and
+// anonymous otherwise. The endpoint can be replaced, e.g. by Azurite, with
+// AZURE_STORAGE_BLOB_ENDPOINT.
+type azureClient struct {
+	account  string
+	key      []byte
+	sasToken string
+	endpoint *url.URL
+}
+
+func newAzureClient() (*azureClient, error) {
+	client := &azureClient{
+		account:  os.Getenv("AZURE_STORAGE_ACCOUNT"),
+		sasToken: strings.TrimPrefix(os.Getenv("AZURE_STORAGE_SAS_TOKEN"), "?"),
+	}
+	if key := os.Getenv("AZURE_STORAGE_KEY"); key != "" {
+		var err error
+		client.key, err = base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AZURE_STORAGE_KEY: %w", err)
+		}
+	}
+	endpoint := os.Getenv("AZURE_STORAGE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://" + client.account + ".blob.core.windows.net"
+	}
+	var err error
+	client.endpoint, err = url.Parse(endpoint)
+	if err != nil || client.endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid AZURE_STORAGE_BLOB_ENDPOINT %q", endpoint)
+	}
+	return client, nil
+}
+
+// objectURL returns the URL of the blob in the container.
+func (client *azureClient) objectURL(container string, blob string) (*url.URL, error) {
+	if client.account == "" || len(container) < 3 || len(container) > 63 || !azureContainerPattern.MatchString(container) || blob == "" {
+		return nil, errInvalidObject
+	}
+	location := *client.endpoint
+	location.Path = strings.TrimSuffix(location.Path, "/") + "/" + container + "/" + blob
+	location.RawPath = ""
+	if client.key == nil {
+		location.RawQuery = client.sasToken
+	}
+	return &location, nil
+}
+
+// authorize signs the request with the Shared Key, if configured.
+func (client *azureClient) authorize(ctx context.Context, request *http.Request) error {
+	request.Header.Set("X-Ms-Version", azureVersion)
+	if client.key == nil {
+		return nil
+	}
+	date := time.Now().UTC().Format(http.TimeFormat)
+	request.Header.Set("X-Ms-Date", date)
+
+	// The standard headers of a GET request without a body are all empty,
+	// see https://learn.microsoft.com/rest/api/storageservices/authorize-with-shared-key.
+	stringToSign := request.Method + strings.Repeat("\n", 12) +
+		"x-ms-date:" + date + "\nx-ms-version:" + azureVersion + "\n" +
+		"/" + client.account + request.URL.EscapedPath()
+	mac := hmac.New(sha256.New, client.key)
+	mac.Write([]byte(stringToSign))
+	request.Header.Set("Authorization", "SharedKey "+client.account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+)
+
+// handleBinaryMetadata streams the value of a binary tag like ThumbnailImage,
+// PreviewImage or JpgFromRaw given by the tag parameter as extracted by
+// exiftool -b. The file is a server-local file given by the path parameter
+// for GET requests, and uploaded like for POST /metadata otherwise.
+func handleBinaryMetadata(ctx context.Context, cache *tagCache, local *localFiles) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		tag := getQueryParameter(r.URL.Query(), "tag")
+		if tag == nil || *tag == "" {
+			http.Error(w, "missing tag parameter", http.StatusBadRequest)
+			return
+		}
+		db, err := cache.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		if !validTagName(db, *tag) {
+			http.Error(w, fmt.Sprintf("invalid tag parameter %q", *tag), http.StatusBadRequest)
+			return
+		}
+
+		serveBinaryTag(ctx, local, w, r, *tag, "")
+	}
+}
+
+// handleXMPPacket streams the raw XMP packet of a file given like for
+// /metadata/binary.
+func handleXMPPacket(ctx context.Context, local *localFiles) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		serveBinaryTag(ctx, local, w, r, "XMP", "application/rdf+xml")
+	}
+}
+
+// serveBinaryTag streams the value of the tag with the content type, which is
+// detected from the value if empty.
+func serveBinaryTag(ctx context.Context, local *localFiles, w http.ResponseWriter, r *http.Request, tag string, contentType string) {
+	var stdin io.Reader
+	var source string
+	switch r.Method {
+	case http.MethodGet:
+		path := getQueryParameter(r.URL.Query(), "path")
+		if path == nil || *path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		resolved, err := local.resolve(*path)
+		switch {
+		case errors.Is(err, errPathNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source = resolved
+	case http.MethodPost:
+		if isMultipart(r) {
+			file, err := saveUpload(r)
+			if err != nil {
+				writeExtractionError(w, r, http.StatusBadRequest, err)
+				return
+			}
+			defer file.remove()
+			source = file.path
+		} else {
+			stdin = r.Body
+			source = "-"
+		}
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	commandCtx, cancel := commandContext(ctx, r)
+	defer cancel()
+	cmd, reader, err := startExiftool(commandCtx, stdin, "-b", "-"+tag, source)
+	if err != nil {
+		writeExtractionError(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	defer func() {
+		waitExiftool(cmd, reader)
+	}()
+
+	buffered := bufio.NewReader(reader)
+	head, err := buffered.Peek(512)
+	if len(head) == 0 {
+		if err != nil && err != io.EOF {
+			log.Printf("Error reading exiftool output: %v\n", err)
+		}
+		writeExtractionError(w, r, http.StatusNotFound, fmt.Errorf("file has no %s tag", tag))
+		return
+	}
+
+	if contentType == "" {
+		contentType = http.DetectContentType(head)
+	}
+	w.Header().Add("Content-Type", contentType)
+	_, err = io.Copy(w, buffered)
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// burstKey is the key of the burst group added to the extracted metadata.
+const burstKey = "Burst"
+
+// burstTags are the tags identifying the camera and the capture time of a
+// file.
+var burstTags = []string{"Make", "Model", "SerialNumber", "SubSecDateTimeOriginal", "DateTimeOriginal", "SubSecTimeOriginal"}
+
+// captureTime returns the capture time of the file with fractional seconds.
+func captureTime(metadata Metadata) (time.Time, bool) {
+	if value, ok := findTag(metadata, "SubSecDateTimeOriginal"); ok {
+		if captured, _, ok := parseExiftoolDate(value); ok {
+			return captured, true
+		}
+	}
+	value, ok := findTag(metadata, "DateTimeOriginal")
+	if !ok {
+		return time.Time{}, false
+	}
+	if subSeconds, ok := findTag(metadata, "SubSecTimeOriginal"); ok {
+		if text, ok := value.(string); ok && len(text) == 19 {
+			value = text + "." + strings.TrimSpace(fmt.Sprint(subSeconds))
+		}
+	}
+	captured, _, ok := parseExiftoolDate(value)
+	return captured, ok
+}
+
+// cameraIdentity returns the make, model and serial number of the camera.
+func cameraIdentity(metadata Metadata) string {
+	var identity []string
+	for _, tag := range []string{"Make", "Model", "SerialNumber"} {
+		value, _ := findTag(metadata, tag)
+		identity = append(identity, fmt.Sprint(value))
+	}
+	return strings.Join(identity, "\x00")
+}
+
+// groupBursts numbers the sequences of files captured by the same camera
+// within the window of the previous file, in the order of their first
+// capture. Files not captured in a burst are not numbered.
+func groupBursts(metadata []Metadata, window time.Duration) {
+	type capture struct {
+		metadata Metadata
+		camera   string
+		time     time.Time
+	}
+	var captures []capture
+	for _, m := range metadata {
+		if captured, ok := captureTime(m); ok {
+			captures = append(captures, capture{m, cameraIdentity(m), captured})
+		}
+	}
+	sort.SliceStable(captures, func(i, j int) bool {
+		return captures[i].time.Before(captures[j].time)
+	})
+
+	var bursts [][]Metadata
+	last := make(map[string]int)
+	previous := make(map[string]time.Time)
+	for _, c := range captures {
+		i, ok := last[c.camera]
+		if !ok || c.time.Sub(previous[c.camera]) > window {
+			i = len(bursts)
+			bursts = append(bursts, nil)
+			last[c.camera] = i
+		}
+		bursts[i] = append(bursts[i], c.metadata)
+		previous[c.camera] = c.time
+	}
+
+	number := 0
+	for _, burst := range bursts {
+		if len(burst) < 2 {
+			continue
+		}
+		number++
+		for _, m := range burst {
+			m[burstKey] = number
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// checksumKey is the key of the checksums added to the extracted metadata.
+const checksumKey = "Checksums"
+
+// checksumAlgorithms are the digests that can be computed of the files.
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// checksums computes digests of everything written to it.
+type checksums struct {
+	names  []string
+	hashes []hash.Hash
+}
+
+func newChecksums(names []string) *checksums {
+	sums := &checksums{names: names}
+	for _, name := range names {
+		sums.hashes = append(sums.hashes, checksumAlgorithms[name]())
+	}
+	return sums
+}
+
+func (sums *checksums) Write(p []byte) (int, error) {
+	for _, h := range sums.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// values returns the hex encoded digests by algorithm.
+func (sums *checksums) values() map[string]string {
+	values := make(map[string]string, len(sums.names))
+	for i, name := range sums.names {
+		values[name] = hex.EncodeToString(sums.hashes[i].Sum(nil))
+	}
+	return values
+}
+
+// fileChecksums computes the digests of the file.
+func fileChecksums(path string, names []string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		closeReader(file)
+	}()
+	sums := newChecksums(names)
+	_, err = io.Copy(sums, file)
+	if err != nil {
+		return nil, fmt.Errorf("error computing checksums: %w", err)
+	}
+	return sums.values(), nil
+}
+
+// addFileChecksums adds the checksums requested by the options of the file
+// to its metadata.
+func addFileChecksums(metadata Metadata, options extractionOptions, path string) error {
+	if len(options.checksums) == 0 {
+		return nil
+	}
+	values, err := fileChecksums(path, options.checksums)
+	if err != nil {
+		return err
+	}
+	metadata[checksumKey] = values
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MetadataChange is a tag whose value differs between two files.
+type MetadataChange struct {
+	Tag    string      `json:"tag"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// MetadataDiff lists the tags only the second file has, the tags only the
+// first file has and the tags whose values differ.
+type MetadataDiff struct {
+	SourceFiles []string         `json:"sourceFiles"`
+	Added       Metadata         `json:"added"`
+	Removed     Metadata         `json:"removed"`
+	Changed     []MetadataChange `json:"changed"`
+}
+
+// diffMetadata compares the metadata of two files.
+func diffMetadata(before Metadata, after Metadata) MetadataDiff {
+	diff := MetadataDiff{Added: make(Metadata), Removed: make(Metadata), Changed: make([]MetadataChange, 0)}
+	for tag, value := range after {
+		previous, ok := before[tag]
+		switch {
+		case !ok:
+			diff.Added[tag] = value
+		case !reflect.DeepEqual(previous, value):
+			diff.Changed = append(diff.Changed, MetadataChange{Tag: tag, Before: previous, After: value})
+		}
+	}
+	for tag, value := range before {
+		if _, ok := after[tag]; !ok {
+			diff.Removed[tag] = value
+		}
+	}
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		return diff.Changed[i].Tag < diff.Changed[j].Tag
+	})
+	return diff
+}
+
+// handleMetadataDiff compares the metadata of two files uploaded like for
+// POST /metadata, e.g. to verify that processing a file preserves its
+// metadata. The file system tags, which differ for every file, are left out,
+// and groups are given as prefixes so every tag is compared by itself.
+func handleMetadataDiff(ctx context.Context, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+		options.groups = strings.Replace(options.groups, "-g", "-G", 1)
+		options.excluded = append(options.excluded, "System:all")
+
+		files, err := saveUploads(r, 2)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer func() {
+			for _, file := range files {
+				file.remove()
+			}
+		}()
+
+		metadata := make([]Metadata, 0, len(files))
+		names := make([]string, 0, len(files))
+		for _, file := range files {
+			m, ok := extractFile(ctx, w, r, options, file)
+			if !ok {
+				return
+			}
+			delete(m, "SourceFile")
+			metadata = append(metadata, m)
+			names = append(names, file.name)
+		}
+		diff := diffMetadata(metadata[0], metadata[1])
+		diff.SourceFiles = names
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, diff)
+	}
+}
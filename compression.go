@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipResponseWriter compresses everything written to the underlying writer.
+// The gzip stream is only started once a body is written, so responses
+// without a body such as 304 stay empty.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	writer *gzip.Writer
+}
+
+func (w *gzipResponseWriter) start() {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.writer = gzip.NewWriter(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.writer == nil && status != http.StatusNotModified && status != http.StatusNoContent {
+		w.start()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.writer == nil {
+		w.start()
+	}
+	return w.writer.Write(b)
+}
+
+// Flush flushes the compressed data written so far to the client.
+func (w *gzipResponseWriter) Flush() {
+	if w.writer != nil {
+		err := w.writer.Flush()
+		if err != nil {
+			log.Printf("Error flushing gzip writer: %v\n", err)
+		}
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack takes over the connection, e.g. for WebSockets, whose responses
+// are not compressed.
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+func (w *gzipResponseWriter) close() {
+	if w.writer == nil {
+		return
+	}
+	err := w.writer.Close()
+	if err != nil {
+		log.Printf("Error closing gzip writer: %v\n", err)
+	}
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(encoding, ";")
+		if strings.TrimSpace(parts[0]) != "gzip" {
+			continue
+		}
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if !strings.HasPrefix(parameter, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(parameter[2:], 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// withGzip compresses the responses of the handler for clients accepting gzip.
+func withGzip(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			handler(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		handler(gw, r)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// computedKey is the key of the computed values added to the extracted
+// metadata.
+const computedKey = "Computed"
+
+// computedTags are the tags the computed values are derived from.
+var computedTags = []string{
+	"ImageWidth", "ImageHeight", "FocalLength", "FocalLengthIn35mmFormat",
+	"ScaleFactor35efl", "ExposureTime", "FNumber", "ISO",
+}
+
+// ComputedValues are conveniences derived from the extracted tags.
+type ComputedValues struct {
+	Megapixels      *float64 `json:"megapixels,omitempty"`
+	AspectRatio     *float64 `json:"aspectRatio,omitempty"`
+	FocalLength35mm *float64 `json:"focalLength35mm,omitempty"`
+	LightValue      *float64 `json:"lightValue,omitempty"`
+	ShutterSpeed    string   `json:"shutterSpeed,omitempty"`
+	Aperture        string   `json:"aperture,omitempty"`
+}
+
+// numericValue parses a number from a value extracted with or without -n,
+// like 50.0 mm or 1/250. Of duplicated values the first one is used.
+func numericValue(value interface{}) (float64, bool) {
+	if values, ok := value.([]interface{}); ok && len(values) > 0 {
+		value = values[0]
+	}
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		fields := strings.Fields(v)
+		if len(fields) == 0 {
+			return 0, false
+		}
+		if i := strings.Index(fields[0], "/"); i > 0 {
+			numerator, err := strconv.ParseFloat(fields[0][:i], 64)
+			if err != nil {
+				return 0, false
+			}
+			denominator, err := strconv.ParseFloat(fields[0][i+1:], 64)
+			if err != nil || denominator == 0 {
+				return 0, false
+			}
+			return numerator / denominator, true
+		}
+		n, err := strconv.ParseFloat(fields[0], 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// tagNumber returns the number of the tag like findTag and numericValue.
+func tagNumber(metadata Metadata, name string) (float64, bool) {
+	value, ok := findTag(metadata, name)
+	if !ok {
+		return 0, false
+	}
+	n, ok := numericValue(value)
+	return n, ok && !math.IsInf(n, 0) && !math.IsNaN(n)
+}
+
+// rounded returns the value rounded to the given number of decimals.
+func rounded(value float64, decimals int) *float64 {
+	scale := math.Pow(10, float64(decimals))
+	value = math.Round(value*scale) / scale
+	return &value
+}
+
+// formatShutterSpeed formats an exposure time in seconds like photographers
+// do, e.g. 1/250 or 2.
+func formatShutterSpeed(seconds float64) string {
+	if seconds < 1 {
+		return fmt.Sprintf("1/%d", int(math.Round(1/seconds)))
+	}
+	return strconv.FormatFloat(*rounded(seconds, 1), 'f', -1, 64)
+}
+
+// computeValues derives the computed values from the metadata.
+func computeValues(metadata Metadata) ComputedValues {
+	var computed ComputedValues
+	width, hasWidth := tagNumber(metadata, "ImageWidth")
+	height, hasHeight := tagNumber(metadata, "ImageHeight")
+	if hasWidth && hasHeight && width > 0 && height > 0 {
+		computed.Megapixels = rounded(width*height/1e6, 2)
+		computed.AspectRatio = rounded(width/height, 3)
+	}
+
+	if focalLength, ok := tagNumber(metadata, "FocalLengthIn35mmFormat"); ok && focalLength > 0 {
+		computed.FocalLength35mm = rounded(focalLength, 1)
+	} else if focalLength, ok := tagNumber(metadata, "FocalLength"); ok && focalLength > 0 {
+		if scale, ok := tagNumber(metadata, "ScaleFactor35efl"); ok && scale > 0 {
+			computed.FocalLength35mm = rounded(focalLength*scale, 1)
+		}
+	}
+
+	exposureTime, hasExposureTime := tagNumber(metadata, "ExposureTime")
+	hasExposureTime = hasExposureTime && exposureTime > 0
+	fNumber, hasFNumber := tagNumber(metadata, "FNumber")
+	hasFNumber = hasFNumber && fNumber > 0
+	if hasExposureTime {
+		computed.ShutterSpeed = formatShutterSpeed(exposureTime)
+	}
+	if hasFNumber {
+		computed.Aperture = "f/" + strconv.FormatFloat(*rounded(fNumber, 1), 'f', -1, 64)
+	}
+	if hasExposureTime && hasFNumber {
+		// The exposure value normalized to ISO 100.
+		lightValue := math.Log2(fNumber * fNumber / exposureTime)
+		if iso, ok := tagNumber(metadata, "ISO"); ok && iso > 0 {
+			lightValue -= math.Log2(iso / 100)
+		}
+		computed.LightValue = rounded(lightValue, 1)
+	}
+	return computed
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var errUnknownConfig = errors.New("unknown config")
+
+// configNamePattern matches the names of the config files.
+var configNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// exiftoolConfigs are the named ExifTool config files defining user-defined
+// tags, stored as NAME.config in a directory of the server. Config files are
+// Perl code, so they cannot be uploaded with a request.
+type exiftoolConfigs struct {
+	dir string
+	// defaults is the cache of the tag database without a config file.
+	defaults *tagCache
+	mu       sync.Mutex
+	tags     map[string]*tagCache
+}
+
+func newExiftoolConfigs(dir string, defaults *tagCache) (*exiftoolConfigs, error) {
+	configs := &exiftoolConfigs{defaults: defaults, tags: make(map[string]*tagCache)}
+	if dir == "" {
+		return configs, nil
+	}
+	resolved, err := filepath.Abs(dir)
+	if err == nil {
+		var info os.FileInfo
+		info, err = os.Stat(resolved)
+		if err == nil && !info.IsDir() {
+			err = errors.New("not a directory")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid config directory %q: %w", dir, err)
+	}
+	configs.dir = resolved
+	return configs, nil
+}
+
+// resolve returns the path of the named config file.
+func (configs *exiftoolConfigs) resolve(name string) (string, error) {
+	if configs.dir == "" || !configNamePattern.MatchString(name) {
+		return "", errUnknownConfig
+	}
+	path := filepath.Join(configs.dir, name+".config")
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", errUnknownConfig
+	}
+	return path, nil
+}
+
+// tagCache returns the cache of the tag database including the tags defined
+// by the named config file.
+func (configs *exiftoolConfigs) tagCache(name string) (*tagCache, error) {
+	path, err := configs.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	configs.mu.Lock()
+	defer configs.mu.Unlock()
+	tags, ok := configs.tags[name]
+	if !ok {
+		tags = &tagCache{config: path}
+		configs.tags[name] = tags
+	}
+	return tags, nil
+}
+
+// tagDatabase returns the tag database including the tags defined by the
+// named config file, or the one without a config file if name is empty,
+// loading it if necessary.
+func (configs *exiftoolConfigs) tagDatabase(ctx context.Context, name string) (*TagDatabase, error) {
+	if name == "" {
+		return configs.defaults.get(ctx)
+	}
+	tags, err := configs.tagCache(name)
+	if err != nil {
+		return nil, err
+	}
+	return tags.get(ctx)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Multipart form fields and query parameters of copy requests.
+const (
+	copySourceField = "source"
+	copyTargetField = "target"
+)
+
+// saveNamedUploads saves the files of the multipart request uploaded with
+// the given fields like saveUpload, by field.
+func saveNamedUploads(r *http.Request, fields ...string) (map[string]upload, error) {
+	files := make(map[string]upload)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		wanted[field] = true
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err == nil && wanted[part.FormName()] && part.FileName() != "" {
+			if _, ok := files[part.FormName()]; !ok {
+				files[part.FormName()], err = saveFile(part, filepath.Base(part.FileName()))
+			}
+		}
+		if err != nil {
+			for _, file := range files {
+				file.remove()
+			}
+			return nil, err
+		}
+	}
+}
+
+// copyLocalFile copies the server-local file into a temporary file.
+func copyLocalFile(path string, name string) (upload, error) {
+	content, err := os.Open(path)
+	if err != nil {
+		return upload{}, err
+	}
+	defer func() {
+		closeReader(content)
+	}()
+	return saveFile(content, name)
+}
+
+// handleCopyMetadata copies tags from a source file to a target file with
+// exiftool -tagsFromFile and returns the modified target, e.g. to re-embed
+// the metadata an image editor stripped. Either file is uploaded as
+// multipart form data with the source and target fields or a server-local
+// file given by the parameter of the same name, which is never modified. The
+// tags parameter selects the tags to copy, all writable tags by default.
+// With dryrun=true, the changes of the target are returned instead.
+func handleCopyMetadata(ctx context.Context, cache *tagCache, local *localFiles) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newWriteOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var tags []string
+		var db *TagDatabase
+		for _, tag := range getQueryList(r.URL.Query(), "tags") {
+			if db == nil {
+				db, err = cache.get(ctx)
+				if err != nil {
+					w.WriteHeader(http.StatusInternalServerError)
+					log.Printf("%v\n", err)
+					return
+				}
+			}
+			if !validTagName(db, tag) {
+				http.Error(w, fmt.Sprintf("invalid tags parameter %q", tag), http.StatusBadRequest)
+				return
+			}
+			tags = append(tags, "-"+tag)
+		}
+
+		files := make(map[string]upload)
+		if isMultipart(r) {
+			files, err = saveNamedUploads(r, copySourceField, copyTargetField)
+			if err != nil {
+				writeExtractionError(w, r, http.StatusBadRequest, err)
+				return
+			}
+		}
+		defer func() {
+			for _, file := range files {
+				file.remove()
+			}
+		}()
+
+		var source string
+		if file, ok := files[copySourceField]; ok {
+			source = file.path
+		}
+		for _, field := range []string{copySourceField, copyTargetField} {
+			if _, ok := files[field]; ok {
+				continue
+			}
+			path := getQueryParameter(r.URL.Query(), field)
+			if path == nil || *path == "" {
+				http.Error(w, fmt.Sprintf("missing %s file", field), http.StatusBadRequest)
+				return
+			}
+			resolved, err := local.resolve(*path)
+			switch {
+			case errors.Is(err, errPathNotAllowed):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case os.IsNotExist(err):
+				http.Error(w, fmt.Sprintf("%s file not found", field), http.StatusNotFound)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if field == copySourceField {
+				source = resolved
+				continue
+			}
+			files[field], err = copyLocalFile(resolved, filepath.Base(resolved))
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("%v\n", err)
+				return
+			}
+		}
+		target := files[copyTargetField]
+
+		writeFile(ctx, w, r, options, target, append([]string{"-tagsFromFile", source}, tags...)...)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// normalizedDatesKey is the key of the normalized dates added to the
+// extracted metadata.
+const normalizedDatesKey = "NormalizedDates"
+
+// dateOffsetTags are the dates normalized with the tags giving their offset
+// from UTC.
+var dateOffsetTags = []struct {
+	date   string
+	offset string
+}{
+	{"DateTimeOriginal", "OffsetTimeOriginal"},
+	{"CreateDate", "OffsetTimeDigitized"},
+	{"ModifyDate", "OffsetTime"},
+}
+
+// normalizedDateTags are the tags the normalized dates are derived from.
+var normalizedDateTags = []string{
+	"DateTimeOriginal", "OffsetTimeOriginal", "CreateDate", "OffsetTimeDigitized",
+	"ModifyDate", "OffsetTime", "GPSDateTime",
+}
+
+// exiftoolDatePattern matches dates as exiftool writes them, e.g.
+// 2024:01:01 10:00:00.123+02:00, with optional fractional seconds and offset.
+var exiftoolDatePattern = regexp.MustCompile(`^(\d{4}):(\d{2}):(\d{2}) (\d{2}):(\d{2}):(\d{2})(\.\d+)?(Z|[+-]\d{2}:\d{2})?$`)
+
+// offsetPattern matches offsets from UTC like +02:00.
+var offsetPattern = regexp.MustCompile(`^([+-])(\d{2}):(\d{2})$`)
+
+// NormalizedDate is a date as RFC 3339 with the source of its offset: the
+// date itself, its offset tag or the difference to the GPS time, which is
+// in UTC.
+type NormalizedDate struct {
+	Value        string `json:"value"`
+	OffsetSource string `json:"offsetSource"`
+}
+
+// parseOffset returns the offset in seconds.
+func parseOffset(value string) (int, bool) {
+	if value == "Z" {
+		return 0, true
+	}
+	match := offsetPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	offset := hours*3600 + minutes*60
+	if match[1] == "-" {
+		offset = -offset
+	}
+	return offset, true
+}
+
+// parseExiftoolDate parses the date, returning whether it has an offset.
+// Dates without one are parsed as UTC.
+func parseExiftoolDate(value interface{}) (time.Time, bool, bool) {
+	if values, ok := value.([]interface{}); ok && len(values) > 0 {
+		value = values[0]
+	}
+	text, _ := value.(string)
+	match := exiftoolDatePattern.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}, false, false
+	}
+	parts := make([]int, 6)
+	for i := range parts {
+		parts[i], _ = strconv.Atoi(match[i+1])
+	}
+	var nanoseconds int
+	if match[7] != "" {
+		fraction, _ := strconv.ParseFloat(match[7], 64)
+		nanoseconds = int(math.Round(fraction * 1e9))
+	}
+	location := time.UTC
+	hasOffset := false
+	if match[8] != "" {
+		offset, ok := parseOffset(match[8])
+		if !ok {
+			return time.Time{}, false, false
+		}
+		location = time.FixedZone("", offset)
+		hasOffset = true
+	}
+	date := time.Date(parts[0], time.Month(parts[1]), parts[2], parts[3], parts[4], parts[5], nanoseconds, location)
+	return date, hasOffset, true
+}
+
+// normalizeDates reconciles the dates of the metadata, which EXIF stores in
+// local time, with the offset tags and the GPS time into RFC 3339 dates with
+// explicit offsets. Dates whose offset cannot be determined are left out.
+func normalizeDates(metadata Metadata) map[string]NormalizedDate {
+	dates := make(map[string]NormalizedDate)
+	gpsValue, _ := findTag(metadata, "GPSDateTime")
+	// The GPS time is in UTC, which dates without an offset are parsed as.
+	gpsTime, _, hasGPSTime := parseExiftoolDate(gpsValue)
+	if hasGPSTime {
+		dates["GPSDateTime"] = NormalizedDate{Value: gpsTime.UTC().Format(time.RFC3339Nano), OffsetSource: "GPSDateTime"}
+	}
+
+	for _, tags := range dateOffsetTags {
+		value, ok := findTag(metadata, tags.date)
+		if !ok {
+			continue
+		}
+		date, hasOffset, ok := parseExiftoolDate(value)
+		if !ok {
+			continue
+		}
+		if hasOffset {
+			dates[tags.date] = NormalizedDate{Value: date.Format(time.RFC3339Nano), OffsetSource: tags.date}
+			continue
+		}
+
+		offset, source := 0, ""
+		if value, ok := findTag(metadata, tags.offset); ok {
+			text, _ := value.(string)
+			if parsed, ok := parseOffset(text); ok {
+				offset, source = parsed, tags.offset
+			}
+		}
+		if source == "" && hasGPSTime {
+			// The local time is assumed to be within a day of the GPS
+			// time, with an offset of whole quarter hours.
+			difference := date.Sub(gpsTime).Seconds()
+			quarters := math.Round(difference / 900)
+			if math.Abs(quarters) <= 14*4 {
+				offset, source = int(quarters)*900, "GPSDateTime"
+			}
+		}
+		if source == "" {
+			continue
+		}
+		local := time.Date(date.Year(), date.Month(), date.Day(), date.Hour(), date.Minute(), date.Second(), date.Nanosecond(), time.FixedZone("", offset))
+		dates[tags.date] = NormalizedDate{Value: local.Format(time.RFC3339Nano), OffsetSource: source}
+	}
+	return dates
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// maxDumpSize limits the size of a tag dump uploaded for comparison.
+const maxDumpSize = 64 << 20
+
+// TagRef identifies a tag by its table and ID, as the path is not unique
+// within a table.
+type TagRef struct {
+	Path string `json:"path"`
+	ID   string `json:"id"`
+}
+
+func newTagRef(tag Tag) TagRef {
+	return TagRef{Path: tag.Path, ID: tag.ID}
+}
+
+// tagKey is the table name and ID of a tag, unique in the tag database.
+type tagKey struct {
+	table string
+	id    string
+}
+
+// TagChange describes a tag whose definition differs between two dumps.
+type TagChange struct {
+	TagRef
+	Fields []string `json:"fields"`
+}
+
+// TagDiff lists the differences between a saved tag dump and the current tag database.
+type TagDiff struct {
+	Added   []TagRef    `json:"added"`
+	Removed []TagRef    `json:"removed"`
+	Changed []TagChange `json:"changed"`
+}
+
+// changedFields returns the names of the JSON fields that differ between the tags.
+func changedFields(previous Tag, current Tag) []string {
+	before := selectFields(previous, nil)
+	after := selectFields(current, nil)
+	fields := make([]string, 0)
+	for field, value := range after {
+		if !bytes.Equal(before[field], value) {
+			fields = append(fields, field)
+		}
+	}
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// handleTagDiff compares a tag dump previously saved from /tags, posted as
+// the request body, against the current tag database.
+func handleTagDiff(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var dump struct {
+			Tags []Tag `json:"tags"`
+		}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxDumpSize)).Decode(&dump)
+		if err != nil {
+			http.Error(w, "invalid tag dump: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		previous := make(map[tagKey]Tag, len(dump.Tags))
+		for _, tag := range dump.Tags {
+			previous[tagKey{tag.Group, tag.ID}] = tag
+		}
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+
+		diff := TagDiff{Added: make([]TagRef, 0), Removed: make([]TagRef, 0), Changed: make([]TagChange, 0)}
+		seen := make(map[tagKey]bool, len(previous))
+		for _, tag := range db.Tags {
+			key := tagKey{tag.Group, tag.ID}
+			seen[key] = true
+			old, ok := previous[key]
+			if !ok {
+				diff.Added = append(diff.Added, newTagRef(tag))
+				continue
+			}
+			tag.CreateDescriptionMap(nil)
+			if fields := changedFields(old, tag); len(fields) > 0 {
+				diff.Changed = append(diff.Changed, TagChange{TagRef: newTagRef(tag), Fields: fields})
+			}
+		}
+		for _, tag := range dump.Tags {
+			if !seen[tagKey{tag.Group, tag.ID}] {
+				diff.Removed = append(diff.Removed, newTagRef(tag))
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, diff)
+	}
+}
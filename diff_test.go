@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTagDiffDuplicatePaths(t *testing.T) {
+	// Tags of the same table can share a path, like the two Flash tags here.
+	db := &TagDatabase{Tags: []Tag{
+		{ID: "1", Path: "Main:Flash", Group: "Main", Type: "int16u"},
+		{ID: "2", Path: "Main:Flash", Group: "Main", Type: "int8u"},
+		{ID: "3", Path: "Main:Make", Group: "Main", Type: "string"},
+	}}
+	dump := `{"tags": [
+		{"id": "1", "path": "Main:Flash", "group": "Main", "type": "int16u", "descriptions": {}},
+		{"id": "2", "path": "Main:Flash", "group": "Main", "type": "int16u", "descriptions": {}},
+		{"id": "4", "path": "Main:Flash", "group": "Main", "type": "int8u", "descriptions": {}}
+	]}`
+	w := httptest.NewRecorder()
+	handleTagDiff(context.Background(), &tagCache{db: db})(w, httptest.NewRequest("POST", "/tags/diff", strings.NewReader(dump)))
+
+	var diff TagDiff
+	err := json.Unmarshal(w.Body.Bytes(), &diff)
+	if err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	want := TagDiff{
+		Added:   []TagRef{{Path: "Main:Make", ID: "3"}},
+		Removed: []TagRef{{Path: "Main:Flash", ID: "4"}},
+		Changed: []TagChange{{TagRef: TagRef{Path: "Main:Flash", ID: "2"}, Fields: []string{"type"}}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff = %+v, want %+v", diff, want)
+	}
+}
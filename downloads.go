@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// downloadLinkLifetime is how long a download link is valid, which is as long
+// as the job that wrote the file is kept.
+const downloadLinkLifetime = jobRetention
+
+var errDownloadsDisabled = errors.New("no output directory configured")
+
+// downloads serves the files write jobs wrote to the output directory with
+// signed links, so they can be passed on to clients without further
+// authentication.
+type downloads struct {
+	dir    string
+	secret []byte
+}
+
+// newDownloads returns the downloads of the output directory, which are
+// disabled if it is empty. Without a secret, a random one is generated, so
+// the links are only valid until the server restarts.
+func newDownloads(dir string, secret string) (*downloads, error) {
+	d := &downloads{secret: []byte(secret)}
+	if dir == "" {
+		return d, nil
+	}
+	resolved, err := filepath.Abs(dir)
+	if err == nil {
+		resolved, err = filepath.EvalSymlinks(resolved)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid output directory %q: %w", dir, err)
+	}
+	d.dir = resolved
+	if len(d.secret) == 0 {
+		d.secret = make([]byte, 32)
+		_, err = rand.Read(d.secret)
+		if err != nil {
+			return nil, fmt.Errorf("error generating download secret: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// createDir creates a new directory below the output directory for the files
+// written by a job.
+func (d *downloads) createDir() (string, error) {
+	if d.dir == "" {
+		return "", errDownloadsDisabled
+	}
+	return ioutil.TempDir(d.dir, "job-")
+}
+
+func (d *downloads) signature(name string, expires int64) string {
+	mac := hmac.New(sha256.New, d.secret)
+	fmt.Fprintf(mac, "%s\n%d", name, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// link returns the signed URL of the file below the output directory under
+// base.
+func (d *downloads) link(base *url.URL, file string) (string, error) {
+	relative, err := filepath.Rel(d.dir, file)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.ToSlash(relative)
+	expires := time.Now().Add(downloadLinkLifetime).Unix()
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", d.signature(name, expires))
+	return base.ResolveReference(&url.URL{Path: "/downloads/" + name, RawQuery: query.Encode()}).String(), nil
+}
+
+// handleDownloads serves a file written by a job with GET
+// /downloads/{path}?expires=...&signature=... as returned in its results.
+func handleDownloads(d *downloads) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if d.dir == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/downloads/")
+		query := r.URL.Query()
+		expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+		if err != nil || name == "" || path.Clean("/"+name) != "/"+name {
+			http.NotFound(w, r)
+			return
+		}
+		if !hmac.Equal([]byte(query.Get("signature")), []byte(d.signature(name, expires))) {
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+		if time.Now().Unix() > expires {
+			http.Error(w, "link expired", http.StatusGone)
+			return
+		}
+
+		file := filepath.Join(d.dir, filepath.FromSlash(name))
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			http.NotFound(w, r)
+			return
+		}
+		serveFile(w, r, upload{name: path.Base(name), path: file})
+	}
+}
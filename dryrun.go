@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// dryRunOptions extract the tags compared by a dry run, prefixed with their
+// groups so tags of the same name in different locations are told apart.
+// The file system tags are left out as they differ for the copy written.
+var dryRunOptions = extractionOptions{groups: "-G1", excluded: []string{"System:all"}}
+
+// previewWrite writes a copy of the file with the arguments and returns how
+// the metadata of the file would change, leaving the file as it is.
+func previewWrite(ctx context.Context, file upload, args ...string) (MetadataDiff, error) {
+	preview, err := copyLocalFile(file.path, file.name)
+	if err != nil {
+		return MetadataDiff{}, err
+	}
+	defer preview.remove()
+
+	err = writeMetadata(ctx, preview.path, args...)
+	if err != nil {
+		return MetadataDiff{}, err
+	}
+	metadata, err := extractMetadata(ctx, dryRunOptions, file.path, preview.path)
+	if err != nil {
+		return MetadataDiff{}, err
+	}
+	bySource := make(map[string]Metadata, len(metadata))
+	for _, m := range metadata {
+		if source, ok := m["SourceFile"].(string); ok {
+			delete(m, "SourceFile")
+			bySource[source] = m
+		}
+	}
+	before, beforeOK := bySource[file.path]
+	after, afterOK := bySource[preview.path]
+	if !beforeOK || !afterOK {
+		return MetadataDiff{}, fmt.Errorf("error extracting the metadata of %s", file.name)
+	}
+	diff := diffMetadata(before, after)
+	diff.SourceFiles = []string{file.name}
+	return diff, nil
+}
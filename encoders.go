@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// tagEncoder writes the tags streamed by the /tags endpoint in one output format.
+type tagEncoder interface {
+	contentType() string
+	begin() error
+	encode(tag Tag) error
+	flush() error
+	end(total int, page pagination) error
+}
+
+// newTagEncoder returns the encoder of the given format, writing only the
+// given fields of every tag unless the list is empty.
+func newTagEncoder(format string, w io.Writer, fields []string, pretty bool) (tagEncoder, error) {
+	switch format {
+	case "", "json":
+		err := validateJSONFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonTagEncoder{w: w, fields: fields, pretty: pretty}, nil
+	case "ndjson":
+		err := validateJSONFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &ndjsonTagEncoder{w: w, fields: fields}, nil
+	case "msgpack":
+		err := validateJSONFields(fields)
+		if err != nil {
+			return nil, err
+		}
+		return &msgpackTagEncoder{w: w, fields: fields}, nil
+	case "parquet":
+		if len(fields) > 0 {
+			return nil, fmt.Errorf("fields are not supported by the parquet format")
+		}
+		return &parquetTagEncoder{w: w}, nil
+	case "protobuf":
+		if len(fields) > 0 {
+			return nil, fmt.Errorf("fields are not supported by the protobuf format")
+		}
+		return &protobufTagEncoder{w: w}, nil
+	case "csv":
+		columns := csvColumns
+		if len(fields) > 0 {
+			for _, field := range fields {
+				if csvValue(Tag{}, field) == nil {
+					return nil, fmt.Errorf("unknown field %q", field)
+				}
+			}
+			columns = fields
+		}
+		return &csvTagEncoder{w: csv.NewWriter(w), columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// validateJSONFields checks that the fields are part of the JSON encoding of a tag.
+func validateJSONFields(fields []string) error {
+	known := jsonSchema(reflect.TypeOf(Tag{}))["properties"].(map[string]interface{})
+	for _, field := range fields {
+		if _, ok := known[field]; !ok {
+			return fmt.Errorf("unknown field %q", field)
+		}
+	}
+	return nil
+}
+
+// writeJSON writes the value as JSON, indented if the request asks for
+// pretty output.
+func writeJSON(w io.Writer, r *http.Request, value interface{}) {
+	encoder := json.NewEncoder(w)
+	if isPretty(r.URL.Query()) {
+		encoder.SetIndent("", "  ")
+	}
+	err := encoder.Encode(value)
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+	}
+}
+
+// isPretty reports whether the pretty query parameter asks for indented JSON.
+func isPretty(query url.Values) bool {
+	value := getQueryParameter(query, "pretty")
+	if value == nil {
+		return false
+	}
+	pretty, err := strconv.ParseBool(*value)
+	return err == nil && pretty
+}
+
+// jsonTagEncoder writes the tags as a JSON object with one tag per line, or
+// indented for humans if pretty is set.
+type jsonTagEncoder struct {
+	w                io.Writer
+	fields           []string
+	pretty           bool
+	includeSeparator bool
+}
+
+func (e *jsonTagEncoder) contentType() string {
+	return "application/json"
+}
+
+func (e *jsonTagEncoder) begin() error {
+	if e.pretty {
+		_, err := io.WriteString(e.w, "{\n  \"tags\": [\n")
+		return err
+	}
+	_, err := io.WriteString(e.w, "{\"tags\":[\n")
+	return err
+}
+
+func (e *jsonTagEncoder) encode(tag Tag) error {
+	var value interface{} = tag
+	if len(e.fields) > 0 {
+		value = selectFields(tag, e.fields)
+	}
+
+	separator := ","
+	if e.pretty {
+		separator = ",\n"
+	}
+	if e.includeSeparator {
+		_, err := io.WriteString(e.w, separator)
+		if err != nil {
+			return err
+		}
+	}
+	e.includeSeparator = true
+
+	if !e.pretty {
+		return json.NewEncoder(e.w).Encode(value)
+	}
+	encoded, err := json.MarshalIndent(value, "    ", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(e.w, "    %s", encoded)
+	return err
+}
+
+// selectFields returns the JSON encoding of the given fields of the tag, or
+// of all fields if none are given.
+func selectFields(tag Tag, fields []string) map[string]json.RawMessage {
+	var all map[string]json.RawMessage
+	encoded, err := json.Marshal(tag)
+	if err == nil {
+		err = json.Unmarshal(encoded, &all)
+	}
+	selected := make(map[string]json.RawMessage, len(fields))
+	if err != nil {
+		return selected
+	}
+	if len(fields) == 0 {
+		return all
+	}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			selected[field] = value
+		}
+	}
+	return selected
+}
+
+func (e *jsonTagEncoder) flush() error {
+	return nil
+}
+
+func (e *jsonTagEncoder) end(total int, page pagination) error {
+	if e.pretty {
+		_, err := fmt.Fprintf(e.w, "\n  ],\n  \"total\": %d,\n  \"offset\": %d", total, page.offset)
+		if err == nil && page.limit != nil {
+			_, err = fmt.Fprintf(e.w, ",\n  \"limit\": %d", *page.limit)
+		}
+		if err == nil {
+			_, err = io.WriteString(e.w, "\n}\n")
+		}
+		return err
+	}
+
+	_, err := fmt.Fprintf(e.w, "],\"total\":%d,\"offset\":%d", total, page.offset)
+	if err != nil {
+		return err
+	}
+	if page.limit != nil {
+		_, err = fmt.Fprintf(e.w, ",\"limit\":%d", *page.limit)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(e.w, "}\n")
+	return err
+}
+
+// ndjsonTagEncoder writes one JSON encoded tag per line without an envelope.
+type ndjsonTagEncoder struct {
+	w      io.Writer
+	fields []string
+}
+
+func (e *ndjsonTagEncoder) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (e *ndjsonTagEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonTagEncoder) encode(tag Tag) error {
+	if len(e.fields) > 0 {
+		return json.NewEncoder(e.w).Encode(selectFields(tag, e.fields))
+	}
+	return json.NewEncoder(e.w).Encode(tag)
+}
+
+func (e *ndjsonTagEncoder) flush() error {
+	return nil
+}
+
+func (e *ndjsonTagEncoder) end(int, pagination) error {
+	return nil
+}
+
+// msgpackTagEncoder writes the tags as a stream of MessagePack maps with the
+// same fields as the JSON encoding.
+type msgpackTagEncoder struct {
+	w      io.Writer
+	fields []string
+}
+
+func (e *msgpackTagEncoder) contentType() string {
+	return "application/msgpack"
+}
+
+func (e *msgpackTagEncoder) begin() error {
+	return nil
+}
+
+func (e *msgpackTagEncoder) encode(tag Tag) error {
+	var value interface{} = tag
+	if len(e.fields) > 0 {
+		value = selectFields(tag, e.fields)
+	}
+	encoded, err := marshalMsgpack(value)
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(encoded)
+	return err
+}
+
+func (e *msgpackTagEncoder) flush() error {
+	return nil
+}
+
+func (e *msgpackTagEncoder) end(int, pagination) error {
+	return nil
+}
+
+// parquetTagEncoder collects the tags and writes them as a Parquet file once
+// all are known.
+type parquetTagEncoder struct {
+	w    io.Writer
+	tags []Tag
+}
+
+func (e *parquetTagEncoder) contentType() string {
+	return "application/vnd.apache.parquet"
+}
+
+func (e *parquetTagEncoder) begin() error {
+	return nil
+}
+
+func (e *parquetTagEncoder) encode(tag Tag) error {
+	e.tags = append(e.tags, tag)
+	return nil
+}
+
+func (e *parquetTagEncoder) flush() error {
+	return nil
+}
+
+func (e *parquetTagEncoder) end(int, pagination) error {
+	return writeParquet(e.w, e.tags)
+}
+
+// protobufTagEncoder writes the tags as a stream of Tag messages of
+// tags.proto, each prefixed with its varint encoded length.
+type protobufTagEncoder struct {
+	w io.Writer
+}
+
+func (e *protobufTagEncoder) contentType() string {
+	return "application/x-protobuf; messageType=exiftool2json.Tag; delimited=true"
+}
+
+func (e *protobufTagEncoder) begin() error {
+	return nil
+}
+
+func (e *protobufTagEncoder) encode(tag Tag) error {
+	message := tag.MarshalProto()
+	_, err := e.w.Write(appendVarint(nil, uint64(len(message))))
+	if err == nil {
+		_, err = e.w.Write(message)
+	}
+	return err
+}
+
+func (e *protobufTagEncoder) flush() error {
+	return nil
+}
+
+func (e *protobufTagEncoder) end(int, pagination) error {
+	return nil
+}
+
+// csvColumns are the columns written by the CSV format unless fields are selected.
+var csvColumns = []string{"path", "group", "type", "writable", "description"}
+
+// csvValue returns the value of the tag in the given CSV column, or nil if the
+// column is unknown.
+func csvValue(tag Tag, column string) *string {
+	var value string
+	switch column {
+	case "path":
+		value = tag.Path
+	case "group":
+		value = tag.Group
+	case "type":
+		value = tag.Type
+	case "writable":
+		value = strconv.FormatBool(tag.Writable)
+	case "description":
+		value = tag.Description("en")
+	default:
+		return nil
+	}
+	return &value
+}
+
+// csvTagEncoder writes the tags as flat CSV rows with the English description.
+type csvTagEncoder struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func (e *csvTagEncoder) contentType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (e *csvTagEncoder) begin() error {
+	return e.w.Write(e.columns)
+}
+
+func (e *csvTagEncoder) encode(tag Tag) error {
+	record := make([]string, len(e.columns))
+	for i, column := range e.columns {
+		record[i] = *csvValue(tag, column)
+	}
+	return e.w.Write(record)
+}
+
+func (e *csvTagEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvTagEncoder) end(int, pagination) error {
+	e.w.Flush()
+	return e.w.Error()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+func closeReader(rc io.ReadCloser) {
+	err := rc.Close()
+	if err != nil {
+		log.Printf("Error closing reader: %v\n", err)
+	}
+}
+
+// exiftoolWorkers limits the number of exiftool commands running at once, if
+// not nil, so a burst of requests cannot exhaust the host. It is set once on
+// startup.
+var exiftoolWorkers chan struct{}
+
+// acquireWorker waits until an exiftool command may run and returns the
+// function to call when it finished.
+func acquireWorker(ctx context.Context) (func(), error) {
+	if exiftoolWorkers == nil {
+		return func() {}, nil
+	}
+	select {
+	case exiftoolWorkers <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-exiftoolWorkers
+		})
+	}, nil
+}
+
+// workerOutput is the standard output of a command started with
+// startExiftool, which releases the worker of the command when closed.
+type workerOutput struct {
+	io.ReadCloser
+	release func()
+}
+
+func (output workerOutput) Close() error {
+	err := output.ReadCloser.Close()
+	output.release()
+	return err
+}
+
+// startExiftool starts exiftool with the given arguments, once a worker is
+// available, and returns the running command together with its standard
+// output. It always starts a new process, see processPool.
+func startExiftool(ctx context.Context, stdin io.Reader, args ...string) (*exec.Cmd, io.ReadCloser, error) {
+	release, err := acquireWorker(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.CommandContext(ctx, "exiftool", args...)
+	cmd.Stdin = stdin
+	reader, err := cmd.StdoutPipe()
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("error piping content: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		release()
+		return nil, nil, fmt.Errorf("error starting: %w", err)
+	}
+	return cmd, workerOutput{reader, release}, nil
+}
+
+// waitExiftool closes the output of a command started with startExiftool and
+// waits for it to exit.
+func waitExiftool(cmd *exec.Cmd, reader io.ReadCloser) {
+	closeReader(reader)
+	err := cmd.Wait()
+	if err != nil {
+		log.Printf("Error waiting for exiftool: %v\n", err)
+	}
+}
+
+// runExiftool runs exiftool with the given arguments and standard input to
+// completion. The standard output is returned even if exiftool exits with an
+// error, since it reports problems with single files that way too. Commands
+// without standard input run in the exiftoolPool if there is one. Either
+// way, the command waits for a worker.
+func runExiftool(ctx context.Context, stdin io.Reader, args ...string) ([]byte, error) {
+	release, err := acquireWorker(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if exiftoolPool != nil && stdin == nil && pooled(args) {
+		return exiftoolPool.run(ctx, args)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "exiftool", args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return stdout.Bytes(), fmt.Errorf("error running exiftool: %s", message)
+	}
+	return stdout.Bytes(), nil
+}
+
+// commandContext returns a context for the commands run by a request, which
+// is cancelled when the request ends or the server context is cancelled.
+func commandContext(ctx context.Context, r *http.Request) (context.Context, context.CancelFunc) {
+	requestContext, cancel := context.WithCancel(r.Context())
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancel()
+		case <-requestContext.Done():
+		}
+	}()
+	return requestContext, cancel
+}
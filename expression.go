@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a compiled jq style filter expression evaluated against the
+// JSON encoding of a tag. It supports paths like .families.g0, string, number
+// and boolean literals, the comparison operators, and, or, not, parentheses
+// and piping into the functions startswith, endswith, contains, test,
+// ascii_downcase, ascii_upcase, length and not, e.g.
+//
+//	.writable == true and .group | startswith("XMP")
+type expression func(value interface{}) (interface{}, error)
+
+// compileExpression parses the source of a filter expression.
+func compileExpression(source string) (expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &expressionParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in filter expression", p.tokens[p.pos].text)
+	}
+	return expr, nil
+}
+
+// matches evaluates the expression against the tag and reports whether the
+// result is truthy, meaning neither false nor null. The descriptions and
+// value labels of the tag in every language are filled in first, as the
+// cached tags only have them parsed.
+func (e expression) matches(tag Tag) (bool, error) {
+	var value interface{}
+	tag.CreateDescriptionMap(nil)
+	encoded, err := json.Marshal(tag)
+	if err != nil {
+		return false, err
+	}
+	err = json.Unmarshal(encoded, &value)
+	if err != nil {
+		return false, err
+	}
+	result, err := e(value)
+	if err != nil {
+		return false, err
+	}
+	return truthy(result), nil
+}
+
+func truthy(value interface{}) bool {
+	if value == nil {
+		return false
+	}
+	if b, ok := value.(bool); ok {
+		return b
+	}
+	return true
+}
+
+type tokenKind int
+
+const (
+	tokenPath tokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdentifier
+	tokenOperator
+)
+
+type expressionToken struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizeExpression(source string) ([]expressionToken, error) {
+	var tokens []expressionToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '.':
+			j := i + 1
+			for j < len(runes) && (runes[j] == '.' || runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, expressionToken{tokenPath, string(runes[i:j])})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string in filter expression")
+			}
+			text, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s in filter expression", string(runes[i:j+1]))
+			}
+			tokens = append(tokens, expressionToken{tokenString, text})
+			i = j + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, expressionToken{tokenNumber, string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, expressionToken{tokenIdentifier, string(runes[i:j])})
+			i = j
+		default:
+			operator := string(c)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "==", "!=", "<=", ">=":
+					operator = two
+				}
+			}
+			switch operator {
+			case "==", "!=", "<=", ">=", "<", ">", "|", "(", ")", ",":
+			default:
+				return nil, fmt.Errorf("unexpected %q in filter expression", operator)
+			}
+			tokens = append(tokens, expressionToken{tokenOperator, operator})
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+type expressionParser struct {
+	tokens []expressionToken
+	pos    int
+}
+
+func (p *expressionParser) peek(kind tokenKind, text string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == kind && p.tokens[p.pos].text == text
+}
+
+func (p *expressionParser) expect(text string) error {
+	if !p.peek(tokenOperator, text) {
+		return fmt.Errorf("expected %q in filter expression", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *expressionParser) parseOr() (expression, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenIdentifier, "or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, true)
+	}
+	return left, nil
+}
+
+func (p *expressionParser) parseAnd() (expression, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenIdentifier, "and") {
+		p.pos++
+		right, err := p.parseComparison()
+		if err != nil {
+			return nil, err
+		}
+		left = logical(left, right, false)
+	}
+	return left, nil
+}
+
+// logical combines two expressions with or if any is set and with and otherwise.
+func logical(left expression, right expression, any bool) expression {
+	return func(value interface{}) (interface{}, error) {
+		a, err := left(value)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(a) == any {
+			return any, nil
+		}
+		b, err := right(value)
+		if err != nil {
+			return nil, err
+		}
+		return truthy(b), nil
+	}
+}
+
+func (p *expressionParser) parseComparison() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenOperator {
+		return left, nil
+	}
+	operator := p.tokens[p.pos].text
+	switch operator {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(value interface{}) (interface{}, error) {
+		a, err := left(value)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(value)
+		if err != nil {
+			return nil, err
+		}
+		return compareValues(operator, a, b)
+	}, nil
+}
+
+func compareValues(operator string, a interface{}, b interface{}) (bool, error) {
+	switch operator {
+	case "==":
+		return equalValues(a, b), nil
+	case "!=":
+		return !equalValues(a, b), nil
+	}
+
+	var order int
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return false, fmt.Errorf("cannot compare number with %T", b)
+		}
+		if x < y {
+			order = -1
+		} else if x > y {
+			order = 1
+		}
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return false, fmt.Errorf("cannot compare string with %T", b)
+		}
+		order = strings.Compare(x, y)
+	default:
+		return false, fmt.Errorf("cannot order %T", a)
+	}
+
+	switch operator {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+func equalValues(a interface{}, b interface{}) bool {
+	x, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	y, err := json.Marshal(b)
+	if err != nil {
+		return false
+	}
+	return string(x) == string(y)
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.peek(tokenIdentifier, "not") {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(value interface{}) (interface{}, error) {
+			result, err := operand(value)
+			if err != nil {
+				return nil, err
+			}
+			return !truthy(result), nil
+		}, nil
+	}
+	return p.parsePipe()
+}
+
+func (p *expressionParser) parsePipe() (expression, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek(tokenOperator, "|") {
+		p.pos++
+		call, err := p.parseCall()
+		if err != nil {
+			return nil, err
+		}
+		input := expr
+		expr = func(value interface{}) (interface{}, error) {
+			piped, err := input(value)
+			if err != nil {
+				return nil, err
+			}
+			return call(value, piped)
+		}
+	}
+	return expr, nil
+}
+
+// parseCall parses a function applied to the piped value. The arguments are
+// evaluated against the tag, not the piped value.
+func (p *expressionParser) parseCall() (func(value interface{}, piped interface{}) (interface{}, error), error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenIdentifier {
+		return nil, fmt.Errorf("expected function after | in filter expression")
+	}
+	name := p.tokens[p.pos].text
+	p.pos++
+
+	var arguments []expression
+	if p.peek(tokenOperator, "(") {
+		p.pos++
+		for {
+			argument, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			arguments = append(arguments, argument)
+			if !p.peek(tokenOperator, ",") {
+				break
+			}
+			p.pos++
+		}
+		err := p.expect(")")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	arity := 0
+	switch name {
+	case "startswith", "endswith", "contains", "test":
+		arity = 1
+	case "ascii_downcase", "ascii_upcase", "length", "not":
+	default:
+		return nil, fmt.Errorf("unknown function %q in filter expression", name)
+	}
+	if len(arguments) != arity {
+		return nil, fmt.Errorf("function %s expects %d arguments", name, arity)
+	}
+
+	var pattern *regexp.Regexp
+	return func(value interface{}, piped interface{}) (interface{}, error) {
+		switch name {
+		case "not":
+			return !truthy(piped), nil
+		case "length":
+			switch v := piped.(type) {
+			case string:
+				return float64(len([]rune(v))), nil
+			case []interface{}:
+				return float64(len(v)), nil
+			case map[string]interface{}:
+				return float64(len(v)), nil
+			case nil:
+				return float64(0), nil
+			default:
+				return nil, fmt.Errorf("%T has no length", piped)
+			}
+		}
+
+		s, ok := piped.(string)
+		if !ok {
+			return nil, fmt.Errorf("function %s expects a string input, got %T", name, piped)
+		}
+		switch name {
+		case "ascii_downcase":
+			return strings.ToLower(s), nil
+		case "ascii_upcase":
+			return strings.ToUpper(s), nil
+		}
+
+		argument, err := arguments[0](value)
+		if err != nil {
+			return nil, err
+		}
+		a, ok := argument.(string)
+		if !ok {
+			return nil, fmt.Errorf("function %s expects a string argument, got %T", name, argument)
+		}
+		switch name {
+		case "startswith":
+			return strings.HasPrefix(s, a), nil
+		case "endswith":
+			return strings.HasSuffix(s, a), nil
+		case "contains":
+			return strings.Contains(s, a), nil
+		default:
+			if pattern == nil || pattern.String() != a {
+				pattern, err = regexp.Compile(a)
+				if err != nil {
+					return nil, fmt.Errorf("invalid regular expression %q: %w", a, err)
+				}
+			}
+			return pattern.MatchString(s), nil
+		}
+	}, nil
+}
+
+func (p *expressionParser) parsePrimary() (expression, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of filter expression")
+	}
+	token := p.tokens[p.pos]
+	p.pos++
+
+	switch token.kind {
+	case tokenPath:
+		var fields []string
+		for _, field := range strings.Split(token.text, ".") {
+			if field != "" {
+				fields = append(fields, field)
+			}
+		}
+		return func(value interface{}) (interface{}, error) {
+			for _, field := range fields {
+				object, ok := value.(map[string]interface{})
+				if !ok {
+					return nil, nil
+				}
+				value = object[field]
+			}
+			return value, nil
+		}, nil
+	case tokenString:
+		return constant(token.text), nil
+	case tokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q in filter expression", token.text)
+		}
+		return constant(number), nil
+	case tokenIdentifier:
+		switch token.text {
+		case "true":
+			return constant(true), nil
+		case "false":
+			return constant(false), nil
+		case "null":
+			return constant(nil), nil
+		}
+	case tokenOperator:
+		if token.text == "(" {
+			expr, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q in filter expression", token.text)
+}
+
+func constant(c interface{}) expression {
+	return func(interface{}) (interface{}, error) {
+		return c, nil
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestExpressionMatchesDescriptions(t *testing.T) {
+	tag := Tag{
+		Path:         "EXIF:ExposureTime",
+		Descriptions: []Description{{Language: "en", Content: "Exposure Time"}, {Language: "de", Content: "Belichtungsdauer"}},
+		Values:       []TagValue{{Key: "1", Labels: []Description{{Language: "en", Content: "Manual"}}}},
+	}
+	tests := []struct {
+		source  string
+		matches bool
+	}{
+		{`.descriptions.en | contains("Exposure")`, true},
+		{`.descriptions.de == "Belichtungsdauer"`, true},
+		{`.descriptions.en | contains("Aperture")`, false},
+	}
+	for _, test := range tests {
+		expr, err := compileExpression(test.source)
+		if err != nil {
+			t.Fatalf("compileExpression(%q): %v", test.source, err)
+		}
+		matched, err := expr.matches(tag)
+		if err != nil || matched != test.matches {
+			t.Errorf("%s matched %v, %v, want %v", test.source, matched, err, test.matches)
+		}
+	}
+	if tag.DescriptionMap != nil {
+		t.Error("matching modified the tag")
+	}
+}
+
+func TestTagFilterDescriptions(t *testing.T) {
+	db := &TagDatabase{Tags: []Tag{
+		{Path: "EXIF:ExposureTime", Descriptions: []Description{{Language: "en", Content: "Exposure Time"}}},
+		{Path: "EXIF:FNumber", Descriptions: []Description{{Language: "en", Content: "F Number"}}},
+	}}
+	filter, err := newTagFilter(url.Values{"filter": {`.descriptions.en | startswith("Exposure")`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	matched := db.filter(filter)
+	if len(matched) != 1 || matched[0].Path != "EXIF:ExposureTime" {
+		t.Errorf("filter matched %v, want EXIF:ExposureTime", matched)
+	}
+}
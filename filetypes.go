@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+)
+
+// FileType is a file type supported by exiftool.
+type FileType struct {
+	Extension   string `xml:"ext,attr"`
+	Description string `xml:"desc,attr"`
+}
+
+// decodeFileTypes reads the output of exiftool -listx -listf.
+func decodeFileTypes(reader io.Reader) ([]FileType, error) {
+	decoder := xml.NewDecoder(reader)
+	fileTypes := make([]FileType, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return fileTypes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := token.(xml.StartElement)
+		if !ok || getXMLAttribute(n.Attr, "ext") == nil {
+			continue
+		}
+		var fileType FileType
+		err = decoder.DecodeElement(&fileType, &n)
+		if err != nil {
+			log.Printf("Error decoding: %v\n", err)
+			continue
+		}
+		fileTypes = append(fileTypes, fileType)
+	}
+}
+
+func handleFileTypes(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		cmd, reader, err := startExiftool(commandCtx, nil, "-listx", "-listf")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		defer waitExiftool(cmd, reader)
+
+		fileTypes, err := decodeFileTypes(reader)
+		if err != nil {
+			cancel()
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+
+		extensions := make([]string, 0, len(fileTypes))
+		descriptions := make(map[string]string, len(fileTypes))
+		for _, fileType := range fileTypes {
+			extensions = append(extensions, fileType.Extension)
+			descriptions[fileType.Extension] = fileType.Description
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, struct {
+			Extensions   []string          `json:"extensions"`
+			Descriptions map[string]string `json:"descriptions"`
+		}{extensions, descriptions})
+	}
+}
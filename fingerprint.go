@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Keys of the fingerprint and duplicate flag added to the extracted
+// metadata.
+const (
+	fingerprintKey = "Fingerprint"
+	duplicateOfKey = "DuplicateOf"
+)
+
+// defaultFingerprintTags identify a capture by the camera, the capture time
+// and the dimensions of the image.
+var defaultFingerprintTags = []string{"SerialNumber", "DateTimeOriginal", "ImageWidth", "ImageHeight"}
+
+// metadataFingerprint hashes the values of the tags. Files with the same
+// fingerprint are likely copies of the same capture, even if their content
+// differs, e.g. after recompression. It reports false unless the file has
+// all the tags, as files missing some, e.g. screenshots without a camera,
+// would otherwise share the fingerprint of the few tags they have.
+func metadataFingerprint(metadata Metadata, tags []string) (string, bool) {
+	hash := sha256.New()
+	for _, tag := range tags {
+		value, ok := findTag(metadata, tag)
+		if !ok {
+			return "", false
+		}
+		_, _ = fmt.Fprintf(hash, "%s=%v\n", tag, value)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// addFingerprint adds the fingerprint of the tags to the metadata.
+func addFingerprint(metadata Metadata, tags []string) {
+	if fingerprint, ok := metadataFingerprint(metadata, tags); ok {
+		metadata[fingerprintKey] = fingerprint
+	}
+}
+
+// duplicateDetector flags the files whose fingerprint equals the one of a
+// file seen before as duplicates of it.
+type duplicateDetector map[string]interface{}
+
+func (seen duplicateDetector) check(metadata Metadata) {
+	fingerprint, ok := metadata[fingerprintKey].(string)
+	if !ok {
+		return
+	}
+	if original, ok := seen[fingerprint]; ok {
+		metadata[duplicateOfKey] = original
+		return
+	}
+	seen[fingerprint] = metadata["SourceFile"]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gcsScope is the OAuth scope of the tokens requested for Cloud Storage.
+const gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// gcsBucketPattern matches valid Cloud Storage bucket names.
+var gcsBucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,220}[a-z0-9]$`)
+
+// serviceAccount is the part of a Google service account key file needed to
+// request access tokens.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	key         *rsa.PrivateKey
+}
+
+// gcsClient reads objects from Google Cloud Storage. Requests are authorized
+// with access tokens of the service account whose key file is named by
+// GOOGLE_APPLICATION_CREDENTIALS and anonymous otherwise. The endpoint can be
+// replaced by an emulator with STORAGE_EMULATOR_HOST.
+type gcsClient struct {
+	endpoint *url.URL
+	account  *serviceAccount
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newGCSClient() (*gcsClient, error) {
+	client := &gcsClient{endpoint: &url.URL{Scheme: "https", Host: "storage.googleapis.com"}}
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		var err error
+		client.endpoint, err = url.Parse(host)
+		if err != nil || client.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid STORAGE_EMULATOR_HOST %q", host)
+		}
+	}
+
+	path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if path == "" {
+		return client, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	account := &serviceAccount{}
+	err = json.Unmarshal(content, account)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, errors.New("missing private key in GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private key of GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	var ok bool
+	account.key, ok = key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key of GOOGLE_APPLICATION_CREDENTIALS is not an RSA key")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	client.account = account
+	return client, nil
+}
+
+// objectURL returns the JSON API media download URL of the object.
+func (client *gcsClient) objectURL(bucket string, key string) (*url.URL, error) {
+	if !gcsBucketPattern.MatchString(bucket) || key == "" {
+		return nil, errInvalidObject
+	}
+	location := *client.endpoint
+	location.Path = "/storage/v1/b/" + bucket + "/o/" + key
+	location.RawPath = "/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(key)
+	location.RawQuery = "alt=media"
+	return &location, nil
+}
+
+// authorize adds an access token of the service account, if configured.
+func (client *gcsClient) authorize(ctx context.Context, request *http.Request) error {
+	if client.account == nil {
+		return nil
+	}
+	token, err := client.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// accessToken returns a cached access token, requesting a new one with a
+// signed JWT assertion shortly before it expires.
+func (client *gcsClient) accessToken(ctx context.Context) (string, error) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.token != "" && time.Now().Before(client.expiry.Add(-time.Minute)) {
+		return client.token, nil
+	}
+
+	assertion, err := client.account.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	request, err := http.NewRequest(http.MethodPost, client.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response, err := downloadClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return "", fmt.Errorf("error requesting access token: %w", err)
+	}
+	defer func() {
+		closeReader(response.Body)
+	}()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error requesting access token: %s", response.Status)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("error decoding access token: %w", err)
+	}
+	client.token = token.AccessToken
+	client.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return client.token, nil
+}
+
+// assertion returns a JWT signed with the key of the service account, which
+// is exchanged for an access token.
+func (account *serviceAccount) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hashed := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, account.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("error signing access token request: %w", err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// gpsTags are the tags extracted for the GeoJSON of a file.
+var gpsTags = []string{"GPSLatitude", "GPSLongitude", "GPSAltitude", "GPSDateTime"}
+
+// GeoJSONGeometry is a GeoJSON Point or LineString.
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// GeoJSONFeature is a GeoJSON Feature locating a file, see RFC 7946.
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// gpsPoint is a position of the file or of one of its embedded documents.
+type gpsPoint struct {
+	document []int
+	position []float64
+	time     string
+}
+
+// documentNumber parses the family 3 group of an embedded document, e.g.
+// Doc2-1, into its numbers. The main document sorts first.
+func documentNumber(group string) []int {
+	var number []int
+	for _, part := range strings.Split(strings.TrimPrefix(group, "Doc"), "-") {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil
+		}
+		number = append(number, n)
+	}
+	return number
+}
+
+func lessDocument(a []int, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// documentTags splits the metadata extracted with -G3, which prefixes every
+// tag with the document it belongs to, into the tags of each document.
+func documentTags(metadata Metadata) map[string]Metadata {
+	documents := make(map[string]Metadata)
+	for key, value := range metadata {
+		i := strings.Index(key, ":")
+		if i < 0 {
+			continue
+		}
+		if documents[key[:i]] == nil {
+			documents[key[:i]] = make(Metadata)
+		}
+		if values, ok := value.([]interface{}); ok && len(values) > 0 {
+			// Duplicates, e.g. from GPS and XMP, extracted with -a.
+			// exiftool extracts the Composite tags last, whose GPS
+			// coordinates are signed by their reference direction
+			// unlike the GPS ones with -n.
+			value = values[len(values)-1]
+		}
+		documents[key[:i]][key[i+1:]] = value
+	}
+	return documents
+}
+
+// gpsPoints collects the positions of the metadata extracted with -G3 in
+// document order.
+func gpsPoints(metadata Metadata) []gpsPoint {
+	var points []gpsPoint
+	for group, tags := range documentTags(metadata) {
+		latitude, latitudeErr := jsonFloat(tags["GPSLatitude"])
+		longitude, longitudeErr := jsonFloat(tags["GPSLongitude"])
+		if latitudeErr != nil || longitudeErr != nil {
+			continue
+		}
+		point := gpsPoint{document: documentNumber(group), position: []float64{longitude, latitude}}
+		if altitude, err := jsonFloat(tags["GPSAltitude"]); err == nil {
+			point.position = append(point.position, altitude)
+		}
+		point.time, _ = tags["GPSDateTime"].(string)
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return lessDocument(points[i].document, points[j].document)
+	})
+	return points
+}
+
+// jsonFloat converts a number extracted with -n to a float.
+func jsonFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	default:
+		return 0, strconv.ErrSyntax
+	}
+}
+
+// newGeoJSONFeature returns a Point feature for a single position and a
+// LineString feature for the track of a file with embedded positions, like
+// the samples of a video.
+func newGeoJSONFeature(source string, points []gpsPoint) GeoJSONFeature {
+	feature := GeoJSONFeature{
+		Type:       "Feature",
+		Properties: map[string]interface{}{"SourceFile": source},
+	}
+	if len(points) == 1 {
+		feature.Geometry = GeoJSONGeometry{Type: "Point", Coordinates: points[0].position}
+		if points[0].time != "" {
+			feature.Properties["time"] = points[0].time
+		}
+		return feature
+	}
+
+	coordinates := make([][]float64, len(points))
+	times := make([]string, len(points))
+	hasTimes := false
+	for i, point := range points {
+		coordinates[i] = point.position
+		times[i] = point.time
+		hasTimes = hasTimes || point.time != ""
+	}
+	feature.Geometry = GeoJSONGeometry{Type: "LineString", Coordinates: coordinates}
+	if hasTimes {
+		feature.Properties["times"] = times
+	}
+	return feature
+}
+
+// handleGeoMetadata extracts the GPS positions of a file sent like for POST
+// /metadata and returns them as a GeoJSON feature.
+func handleGeoMetadata(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options := extractionOptions{
+			tags:       gpsTags,
+			groups:     "-G3",
+			numeric:    true,
+			embedded:   1,
+			duplicates: true,
+		}
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+		points := gpsPoints(metadata)
+		if len(points) == 0 {
+			http.Error(w, "no GPS position extracted", http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/geo+json")
+		writeJSON(w, r, newGeoJSONFeature(metadata["SourceFile"].(string), points))
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+// trackField is the multipart form field of write requests holding a GPS
+// track log, like a GPX file, to geotag the files with.
+const trackField = "track"
+
+// geosyncPattern matches the time differences between the clock of the
+// camera and the GPS time exiftool corrects, in seconds or as hours, minutes
+// and seconds, e.g. +1:00:00.
+var geosyncPattern = regexp.MustCompile(`^[+-]?\d+(:\d+){0,2}(\.\d+)?$`)
+
+// getGeosync returns the geosync parameter, which is the time the camera
+// clock is ahead of the GPS time.
+func getGeosync(query url.Values) (string, error) {
+	value := getQueryParameter(query, "geosync")
+	if value == nil || *value == "" {
+		return "", nil
+	}
+	if !geosyncPattern.MatchString(*value) {
+		return "", fmt.Errorf("invalid geosync parameter %q", *value)
+	}
+	return *value, nil
+}
+
+// geotagArgs returns the exiftool arguments writing the positions of the
+// track interpolated at the capture times of the files.
+func geotagArgs(track upload, geosync string) []string {
+	args := []string{"-geotag", track.path}
+	if geosync != "" {
+		args = append(args, "-geosync="+geosync)
+	}
+	return args
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// Group is a tag table listed by the /groups endpoint.
+type Group struct {
+	Table
+	TagCount int `json:"tagCount"`
+}
+
+func handleGroups(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		if notModified(w, r, db.ETag) {
+			return
+		}
+
+		counts := make(map[string]int, len(db.Tables))
+		for _, tag := range db.Tags {
+			counts[tag.Group]++
+		}
+		groups := make([]Group, 0, len(db.Tables))
+		for _, table := range db.Tables {
+			groups = append(groups, Group{Table: table, TagCount: counts[table.Name]})
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, struct {
+			Groups []Group `json:"groups"`
+		}{groups})
+	}
+}
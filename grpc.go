@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html.
+const (
+	grpcOK              = 0
+	grpcInvalidArgument = 3
+	grpcInternal        = 13
+	grpcUnimplemented   = 12
+)
+
+// maxGRPCRequestSize limits the size of a gRPC request message.
+const maxGRPCRequestSize = 1 << 20
+
+// decodeListTagsRequest converts a ListTagsRequest message of tags.proto to
+// the query parameters of the /tags endpoint.
+func decodeListTagsRequest(message []byte) (url.Values, error) {
+	query := make(url.Values)
+	err := decodeProtoFields(message, func(field protoField) error {
+		switch field.number {
+		case 1:
+			query.Add("group", string(field.bytes))
+		case 2:
+			query.Add("writable", strconv.FormatBool(field.varint != 0))
+		case 3:
+			query.Add("type", string(field.bytes))
+		case 4:
+			query.Add("q", string(field.bytes))
+		case 5:
+			query.Add("lang", string(field.bytes))
+		case 6:
+			query.Add("composite", strconv.FormatBool(field.varint != 0))
+		case 7:
+			query.Add("flags", string(field.bytes))
+		case 8:
+			query.Add("filter", string(field.bytes))
+		case 9:
+			query.Add("path_regex", string(field.bytes))
+		}
+		return nil
+	})
+	return query, err
+}
+
+// readGRPCMessage reads one length prefixed message of a gRPC request.
+func readGRPCMessage(reader io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	_, err := io.ReadFull(reader, prefix[:])
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, fmt.Errorf("compressed messages are not supported")
+	}
+	length := binary.BigEndian.Uint32(prefix[1:])
+	if length > maxGRPCRequestSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the limit", length)
+	}
+	message := make([]byte, length)
+	_, err = io.ReadFull(reader, message)
+	return message, err
+}
+
+func writeGRPCMessage(w io.Writer, message []byte) error {
+	var prefix [5]byte
+	binary.BigEndian.PutUint32(prefix[1:], uint32(len(message)))
+	_, err := w.Write(prefix[:])
+	if err == nil {
+		_, err = w.Write(message)
+	}
+	return err
+}
+
+// setGRPCStatus sets the status trailers of a gRPC response.
+func setGRPCStatus(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set("Grpc-Message", url.PathEscape(message))
+	}
+}
+
+// handleGRPC serves the TagService of tags.proto over HTTP/2.
+func handleGRPC(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			http.Error(w, "gRPC requires HTTP/2 and the application/grpc content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc+proto")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		if r.URL.Path != "/exiftool2json.TagService/ListTags" {
+			setGRPCStatus(w, grpcUnimplemented, "unknown method "+r.URL.Path)
+			return
+		}
+
+		message, err := readGRPCMessage(r.Body)
+		if err != nil {
+			setGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		query, err := decodeListTagsRequest(message)
+		if err != nil {
+			setGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		filter, err := newTagFilter(query)
+		if err != nil {
+			setGRPCStatus(w, grpcInvalidArgument, err.Error())
+			return
+		}
+		languages := getLanguages(query)
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			log.Printf("%v\n", err)
+			setGRPCStatus(w, grpcInternal, "error loading the tag database")
+			return
+		}
+
+		flusher, _ := w.(http.Flusher)
+		for _, tag := range db.filter(filter) {
+			tag.CreateDescriptionMap(languages)
+			err = writeGRPCMessage(w, tag.MarshalProto())
+			if err != nil {
+				log.Printf("Error writing: %v\n", err)
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		setGRPCStatus(w, grpcOK, "")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+)
+
+// handleHTMLDump streams the annotated hex dump exiftool -htmlDump writes of
+// the structure of a file, for inspecting it byte by byte. The file is a
+// server-local file given by the path parameter for GET requests, and
+// uploaded like for POST /metadata otherwise.
+func handleHTMLDump(ctx context.Context, local *localFiles) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		file, remove, ok := requestFile(local, w, r)
+		if !ok {
+			return
+		}
+		defer remove()
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		cmd, reader, err := startExiftool(commandCtx, nil, "-htmlDump", file.path)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		defer func() {
+			waitExiftool(cmd, reader)
+		}()
+
+		w.Header().Add("Content-Type", "text/html; charset=utf-8")
+		// The dump is generated from untrusted content, so it is kept
+		// from running its scripts with the origin of the service.
+		w.Header().Add("Content-Security-Policy", "sandbox allow-scripts")
+		_, err = io.Copy(w, reader)
+		if err != nil {
+			log.Printf("Error writing: %v\n", err)
+		}
+	}
+}
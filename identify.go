@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// FileIdentity is the type of a file as recognized by exiftool.
+type FileIdentity struct {
+	SourceFile        string `json:"sourceFile"`
+	FileType          string `json:"fileType"`
+	MIMEType          string `json:"mimeType"`
+	FileTypeExtension string `json:"fileTypeExtension"`
+}
+
+// handleIdentify identifies the type of a file sent like for POST /metadata.
+// Only the file type tags are extracted with -fast2, which avoids reading
+// more of the file than needed.
+func handleIdentify(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options := extractionOptions{
+			tags: []string{"FileType", "MIMEType", "FileTypeExtension"},
+			fast: 2,
+		}
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+		if metadata["FileType"] == nil {
+			http.Error(w, "unknown file type", http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, FileIdentity{
+			SourceFile:        fmt.Sprint(metadata["SourceFile"]),
+			FileType:          fmt.Sprint(metadata["FileType"]),
+			MIMEType:          fmt.Sprint(metadata["MIMEType"]),
+			FileTypeExtension: fmt.Sprint(metadata["FileTypeExtension"]),
+		})
+	}
+}
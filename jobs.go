@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limits of the asynchronous jobs.
+const (
+	jobWorkers    = 2
+	maxQueuedJobs = 100
+	jobRetention  = time.Hour
+)
+
+// Statuses of a job.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+var errJobQueueFull = errors.New("too many queued jobs")
+
+// Job is the status of an asynchronous job.
+type Job struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// JobEvent is an event of a job. The events of a job are numbered from 1,
+// so clients can resume after the last event they received.
+type JobEvent struct {
+	ID   int         `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+}
+
+// Types of job events.
+const (
+	// jobStatusEvent reports the Job whenever its status changes.
+	jobStatusEvent = "status"
+	// jobProgressEvent reports the result of a processed file as a
+	// JobProgress.
+	jobProgressEvent = "progress"
+)
+
+// JobProgress is the result of a file processed by a job.
+type JobProgress struct {
+	Processed int         `json:"processed"`
+	Total     int         `json:"total"`
+	Result    interface{} `json:"result"`
+}
+
+// jobRunner runs a job, reporting the progress of every file.
+type jobRunner func(ctx context.Context, progress func(JobProgress)) (interface{}, error)
+
+type job struct {
+	Job
+	run     jobRunner
+	cleanup func()
+	results interface{}
+	events  []JobEvent
+	// changed is closed and replaced when an event is published.
+	changed chan struct{}
+	// callback is the webhook notified when the job finished, with a link
+	// to the results.
+	callback   *url.URL
+	resultsURL string
+	// output is the file produced by the job, served from
+	// /jobs/{id}/archive, or the directory of the files it wrote, which is
+	// removed with the job.
+	output string
+}
+
+// jobQueue runs jobs in the background with a fixed number of workers and
+// keeps them for jobRetention after they finished.
+type jobQueue struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue chan *job
+	// webhookSecret signs the webhook requests.
+	webhookSecret []byte
+}
+
+func newJobQueue(ctx context.Context, workers int, webhookSecret string) *jobQueue {
+	jobs := &jobQueue{
+		jobs:          make(map[string]*job),
+		queue:         make(chan *job, maxQueuedJobs),
+		webhookSecret: []byte(webhookSecret),
+	}
+	for i := 0; i < workers; i++ {
+		go jobs.work(ctx)
+	}
+	return jobs
+}
+
+func newJobID() (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", fmt.Errorf("error generating job id: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// enqueue adds a job running the function, which is followed by cleanup even
+// if the job never runs. If callback is not nil, it is notified when the job
+// finished with the URL of its results under base. If output is not empty,
+// it is the file or directory the job produces, which is kept until the job
+// is removed.
+func (jobs *jobQueue) enqueue(run jobRunner, cleanup func(), callback *url.URL, base *url.URL, output string) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		cleanup()
+		removeJobOutput(output)
+		return Job{}, err
+	}
+	j := &job{
+		Job:      Job{ID: id, Status: jobQueued, Created: time.Now()},
+		run:      run,
+		cleanup:  cleanup,
+		changed:  make(chan struct{}),
+		callback: callback,
+		output:   output,
+	}
+	if base != nil {
+		j.resultsURL = base.ResolveReference(&url.URL{Path: "/jobs/" + id + "/results"}).String()
+	}
+
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	jobs.prune()
+	select {
+	case jobs.queue <- j:
+		jobs.jobs[id] = j
+		jobs.publish(j, jobStatusEvent, j.Job)
+		return j.Job, nil
+	default:
+		cleanup()
+		removeJobOutput(output)
+		return Job{}, errJobQueueFull
+	}
+}
+
+// removeJobOutput removes the file or directory produced by a job, if any.
+func removeJobOutput(output string) {
+	if output == "" {
+		return
+	}
+	err := os.RemoveAll(output)
+	if err != nil {
+		log.Printf("Error removing job output: %v\n", err)
+	}
+}
+
+// prune removes the jobs that finished more than jobRetention ago. It has to
+// be called with the lock held.
+func (jobs *jobQueue) prune() {
+	for id, j := range jobs.jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > jobRetention {
+			delete(jobs.jobs, id)
+			removeJobOutput(j.output)
+		}
+	}
+}
+
+func (jobs *jobQueue) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-jobs.queue:
+			jobs.execute(ctx, j)
+		}
+	}
+}
+
+func (jobs *jobQueue) execute(ctx context.Context, j *job) {
+	defer j.cleanup()
+
+	started := time.Now()
+	jobs.mu.Lock()
+	j.Status = jobRunning
+	j.Started = &started
+	jobs.publish(j, jobStatusEvent, j.Job)
+	jobs.mu.Unlock()
+
+	results, err := j.run(ctx, func(progress JobProgress) {
+		jobs.mu.Lock()
+		defer jobs.mu.Unlock()
+		jobs.publish(j, jobProgressEvent, progress)
+	})
+
+	finished := time.Now()
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j.Finished = &finished
+	if err != nil {
+		log.Printf("Job %s failed: %v\n", j.ID, err)
+		j.Status = jobFailed
+		j.Error = err.Error()
+	} else {
+		j.Status = jobSucceeded
+		j.results = results
+	}
+	jobs.publish(j, jobStatusEvent, j.Job)
+
+	if j.callback != nil {
+		notification := JobNotification{Job: j.Job}
+		if j.Status == jobSucceeded {
+			notification.Results = j.resultsURL
+		}
+		go notify(ctx, j.callback, jobs.webhookSecret, notification)
+	}
+}
+
+// publish adds an event to the job and wakes up its subscribers. It has to be
+// called with the lock held.
+func (jobs *jobQueue) publish(j *job, eventType string, data interface{}) {
+	j.events = append(j.events, JobEvent{ID: len(j.events) + 1, Type: eventType, Data: data})
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// events returns the events of the job after the given event ID, whether the
+// job finished, so no more events follow, and a channel closed once there
+// are further events.
+func (jobs *jobQueue) events(id string, after int) ([]JobEvent, bool, <-chan struct{}, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := jobs.jobs[id]
+	if !ok {
+		return nil, false, nil, false
+	}
+	var events []JobEvent
+	if after >= 0 && after < len(j.events) {
+		events = j.events[after:]
+	}
+	return events, j.Finished != nil, j.changed, true
+}
+
+// get returns the status and, once it succeeded, the results of the job.
+func (jobs *jobQueue) get(id string) (Job, interface{}, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := jobs.jobs[id]
+	if !ok {
+		return Job{}, nil, false
+	}
+	return j.Job, j.results, true
+}
+
+// output returns the status and the file produced by the job.
+func (jobs *jobQueue) output(id string) (Job, string, bool) {
+	jobs.mu.Lock()
+	defer jobs.mu.Unlock()
+	j, ok := jobs.jobs[id]
+	if !ok {
+		return Job{}, "", false
+	}
+	return j.Job, j.output, true
+}
+
+// withJobLimits applies the limits to the job requests except the
+// event streams at /jobs/{id}/ws and /jobs/{id}/events, which last as long as
+// the job instead of the timeout of an extraction.
+func withJobLimits(limits extractionLimits, handler http.HandlerFunc) http.HandlerFunc {
+	limited := withLimits(limits, handler)
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		if strings.HasSuffix(path, "/ws") || strings.HasSuffix(path, "/events") {
+			handler(w, r)
+			return
+		}
+		limited(w, r)
+	}
+}
+
+// handleJobs enqueues a batch extraction of an archive uploaded like for
+// POST /metadata/batch with POST /jobs, a batch write with POST /jobs/write
+// and the writing of XMP sidecars with POST /jobs/sidecars, and serves the
+// status of a job with GET /jobs/{id}, its results with GET
+// /jobs/{id}/results, the archive it produced with GET /jobs/{id}/archive and
+// its events over a WebSocket at /jobs/{id}/ws or as Server-Sent Events from
+// /jobs/{id}/events.
+func handleJobs(jobs *jobQueue, configs *exiftoolConfigs, writable *localFiles, d *downloads) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		switch path {
+		case "":
+			createJob(jobs, configs, w, r)
+			return
+		case "write":
+			createWriteJob(jobs, writable, d, false, w, r)
+			return
+		case "sidecars":
+			createWriteJob(jobs, writable, d, true, w, r)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := path
+		var resource string
+		if i := strings.Index(path, "/"); i >= 0 {
+			id, resource = path[:i], path[i+1:]
+		}
+		switch resource {
+		case "", "results":
+		case "ws":
+			serveJobWebSocket(jobs, w, r, id)
+			return
+		case "events":
+			serveJobEvents(jobs, w, r, id)
+			return
+		case "archive":
+			serveJobOutput(jobs, w, r, id)
+			return
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		status, output, ok := jobs.get(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		if resource == "" {
+			writeJSON(w, r, status)
+			return
+		}
+		if status.Status != jobSucceeded {
+			writeError(w, r, http.StatusConflict, fmt.Sprintf("job is %s", status.Status))
+			return
+		}
+		writeJSON(w, r, output)
+	}
+}
+
+// requestBaseURL returns the URL of the server the client sent the request
+// to.
+func requestBaseURL(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host}
+}
+
+// createJob enqueues the extraction of the uploaded archive and responds with
+// 202 and the location of the job. The callback parameter registers a
+// webhook called when the job finished.
+func createJob(jobs *jobQueue, configs *exiftoolConfigs, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	options, err := newExtractionOptions(r.Context(), r.URL.Query(), configs)
+	if err != nil {
+		writeOptionsError(w, err)
+		return
+	}
+	callback, err := jobs.callback(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	archive, err := saveUpload(r)
+	if err != nil {
+		writeExtractionError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	created, err := jobs.enqueue(func(ctx context.Context, progress func(JobProgress)) (interface{}, error) {
+		return extractArchiveMetadata(ctx, options, archive, func(processed int, total int, metadata Metadata) {
+			progress(JobProgress{Processed: processed, Total: total, Result: metadata})
+		})
+	}, archive.remove, callback, requestBaseURL(r), "")
+	switch {
+	case errors.Is(err, errJobQueueFull):
+		writeError(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+created.ID)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, r, created)
+}
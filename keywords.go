@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// keywordTags are the list tags holding the keywords of a file by the names
+// of the fields parameter of /metadata/keywords.
+var keywordTags = map[string]string{
+	"keywords": "IPTC:Keywords",
+	"subject":  "XMP-dc:Subject",
+}
+
+// keywordValues returns the non-empty values of the repeated query
+// parameter. Keywords may contain commas, so they are not split like
+// getQueryList does.
+func keywordValues(query url.Values, name string) []string {
+	var values []string
+	for _, value := range query[name] {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// keywordArgs returns the exiftool arguments removing and adding the values
+// of the list tags. An added value is removed first, so it is not listed
+// twice if the file already has it.
+func keywordArgs(tags []string, add []string, remove []string) []string {
+	var args []string
+	for _, tag := range tags {
+		for _, value := range remove {
+			args = append(args, "-"+tag+"-="+value)
+		}
+		for _, value := range add {
+			args = append(args, "-"+tag+"-="+value, "-"+tag+"+="+value)
+		}
+	}
+	return args
+}
+
+// handleKeywords adds the values of the repeated add parameter to and removes
+// those of the remove parameter from the keywords of a file uploaded as
+// multipart form data or sent as the raw request body, and returns the
+// modified file. The fields parameter selects the IPTC keywords, the XMP
+// subject or both, which is the default as applications read either. With
+// dryrun=true, the changes are returned instead.
+func handleKeywords(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		options, err := newWriteOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fields := getQueryList(query, "fields")
+		if len(fields) == 0 {
+			fields = []string{"keywords", "subject"}
+		}
+		tags := make([]string, 0, len(fields))
+		for _, field := range fields {
+			tag, ok := keywordTags[strings.ToLower(field)]
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid fields parameter %q", field), http.StatusBadRequest)
+				return
+			}
+			tags = append(tags, tag)
+		}
+		args := keywordArgs(tags, keywordValues(query, "add"), keywordValues(query, "remove"))
+		if len(args) == 0 {
+			http.Error(w, "missing add or remove parameter", http.StatusBadRequest)
+			return
+		}
+
+		file, ok := saveRequestFile(w, r)
+		if !ok {
+			return
+		}
+		defer file.remove()
+
+		writeFile(ctx, w, r, options, file, args...)
+	}
+}
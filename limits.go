@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// extractionLimits bound the time and input size of the extraction requests.
+type extractionLimits struct {
+	maxTimeout time.Duration
+	maxSize    int64
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// limitedBody fails reading the request body once it exceeds the maximum
+// size, remembering that it did for reporting the error.
+type limitedBody struct {
+	io.ReadCloser
+	limit     int64
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.remaining {
+		n = int(b.remaining)
+		b.exceeded = true
+		err = errBodyTooLarge
+	}
+	b.remaining -= int64(n)
+	return n, err
+}
+
+type limitedBodyKey struct{}
+
+// withLimits limits the size of the request body and the time the request
+// may take, which defaults to the maximum and can be shortened by the
+// timeout parameter, e.g. timeout=10s. The request context is cancelled at
+// the deadline, killing the exiftool commands started for it.
+func withLimits(limits extractionLimits, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		timeout := limits.maxTimeout
+		if value := getQueryParameter(r.URL.Query(), "timeout"); value != nil {
+			requested, err := time.ParseDuration(*value)
+			if err != nil || requested <= 0 {
+				http.Error(w, fmt.Sprintf("invalid timeout parameter %q", *value), http.StatusBadRequest)
+				return
+			}
+			if requested < timeout {
+				timeout = requested
+			}
+		}
+		if r.ContentLength > limits.maxSize {
+			writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", limits.maxSize))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		body := &limitedBody{ReadCloser: r.Body, limit: limits.maxSize, remaining: limits.maxSize}
+		r = r.WithContext(context.WithValue(ctx, limitedBodyKey{}, body))
+		r.Body = body
+		handler(w, r)
+	}
+}
+
+// ErrorResponse is the body of the responses of failed extractions that
+// exceeded a limit.
+type ErrorResponse struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// writeError writes the error as an ErrorResponse.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	writeJSON(w, r, ErrorResponse{Status: status, Error: message})
+}
+
+// writeExtractionError writes the error of an extraction with the status,
+// unless it was caused by exceeding the size limit or the timeout of the
+// request, which are reported with 413 and 408. Internal server errors are
+// only logged.
+func writeExtractionError(w http.ResponseWriter, r *http.Request, status int, err error) {
+	body, _ := r.Context().Value(limitedBodyKey{}).(*limitedBody)
+	switch {
+	case body != nil && body.exceeded:
+		writeError(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body exceeds %d bytes", body.limit))
+	case errors.Is(err, errDownloadTooLarge) || errors.Is(err, errArchiveTooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, err.Error())
+	case r.Context().Err() == context.DeadlineExceeded:
+		writeError(w, r, http.StatusRequestTimeout, "extraction timed out")
+	case status == http.StatusInternalServerError:
+		w.WriteHeader(status)
+		log.Printf("%v\n", err)
+	default:
+		http.Error(w, err.Error(), status)
+	}
+}
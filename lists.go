@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// decodeList reads the plain text output of the exiftool -list options, which
+// consists of header lines ending with a colon followed by indented lines of
+// whitespace separated names.
+func decodeList(reader io.Reader) ([]string, error) {
+	names := make([]string, 0)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, " ") && strings.HasSuffix(strings.TrimSpace(line), ":") {
+			continue
+		}
+		names = append(names, strings.Fields(line)...)
+	}
+	return names, scanner.Err()
+}
+
+// handleList serves the names listed by exiftool with the given arguments as
+// a JSON object with the names under the given key.
+func handleList(ctx context.Context, key string, args ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		cmd, reader, err := startExiftool(commandCtx, nil, args...)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		defer waitExiftool(cmd, reader)
+
+		names, err := decodeList(reader)
+		if err != nil {
+			cancel()
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, map[string][]string{key: names})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var errPathNotAllowed = errors.New("path is outside of the allowed directories")
+
+// localFiles gives access to the files below the allowed directories of the
+// server, after resolving symbolic links.
+type localFiles struct {
+	dirs []string
+}
+
+// newLocalFiles parses the list of allowed directories separated like PATH.
+func newLocalFiles(list string) (*localFiles, error) {
+	local := &localFiles{}
+	for _, dir := range filepath.SplitList(list) {
+		if dir == "" {
+			continue
+		}
+		resolved, err := filepath.Abs(dir)
+		if err == nil {
+			resolved, err = filepath.EvalSymlinks(resolved)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed directory %q: %w", dir, err)
+		}
+		local.dirs = append(local.dirs, resolved)
+	}
+	return local, nil
+}
+
+// resolve returns the real path of the regular file, failing with
+// errPathNotAllowed if it is not below one of the allowed directories.
+func (local *localFiles) resolve(path string) (string, error) {
+	resolved, info, err := local.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%q is not a regular file", path)
+	}
+	return resolved, nil
+}
+
+// resolveDir returns the real path of the directory like resolve.
+func (local *localFiles) resolveDir(path string) (string, error) {
+	resolved, info, err := local.resolvePath(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%q is not a directory", path)
+	}
+	return resolved, nil
+}
+
+func (local *localFiles) resolvePath(path string) (string, os.FileInfo, error) {
+	resolved, err := filepath.Abs(path)
+	if err == nil {
+		resolved, err = filepath.EvalSymlinks(resolved)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	allowed := false
+	for _, dir := range local.dirs {
+		if resolved == dir || strings.HasPrefix(resolved, dir+string(filepath.Separator)) || dir == string(filepath.Separator) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", nil, errPathNotAllowed
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+	return resolved, info, nil
+}
+
+// requestFile returns the server-local file given by the path parameter of a
+// GET request, or the file of a POST request uploaded as multipart form data
+// or sent as the raw request body, which is saved to a temporary file for
+// tools needing random access to it. If it fails, the error is written to the
+// client and false is returned. The returned function removes a saved file.
+func requestFile(local *localFiles, w http.ResponseWriter, r *http.Request) (upload, func(), bool) {
+	switch r.Method {
+	case http.MethodGet:
+		path := getQueryParameter(r.URL.Query(), "path")
+		if path == nil || *path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return upload{}, nil, false
+		}
+		resolved, err := local.resolve(*path)
+		switch {
+		case errors.Is(err, errPathNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return upload{}, nil, false
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+			return upload{}, nil, false
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return upload{}, nil, false
+		}
+		return upload{name: *path, path: resolved}, func() {}, true
+	case http.MethodPost:
+		file, ok := saveRequestFile(w, r)
+		if !ok {
+			return upload{}, nil, false
+		}
+		return file, file.remove, true
+	default:
+		w.Header().Set("Allow", http.MethodGet+", "+http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return upload{}, nil, false
+	}
+}
+
+// saveRequestFile saves the file uploaded as multipart form data or sent as
+// the raw request body to a temporary file. If it fails, the error is written
+// to the client and false is returned.
+func saveRequestFile(w http.ResponseWriter, r *http.Request) (upload, bool) {
+	var file upload
+	var err error
+	if isMultipart(r) {
+		file, err = saveUpload(r)
+	} else {
+		name := "-"
+		if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+			name = filepath.Base(params["filename"])
+		}
+		file, err = saveFile(r.Body, name)
+	}
+	if err != nil {
+		writeExtractionError(w, r, http.StatusBadRequest, err)
+		return upload{}, false
+	}
+	return file, true
+}
+
+// handleLocalMetadata extracts the metadata of a file in one of the allowed
+// directories of the server given by the path parameter.
+func handleLocalMetadata(ctx context.Context, local *localFiles, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+		path := getQueryParameter(r.URL.Query(), "path")
+		if path == nil || *path == "" {
+			http.Error(w, "missing path parameter", http.StatusBadRequest)
+			return
+		}
+		resolved, err := local.resolve(*path)
+		switch {
+		case errors.Is(err, errPathNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		serveMetadata(ctx, w, r, options, upload{name: *path, path: resolved})
+	}
+}
@@ -2,153 +2,151 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"encoding/xml"
-	"fmt"
-	"io"
+	"flag"
 	"log"
 	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"runtime"
 	"syscall"
 	"time"
 )
 
-type Description struct {
-	Language string `xml:"lang,attr"`
-	Content  string `xml:",chardata"`
-}
-
-type Tag struct {
-	Writable       bool              `json:"writable" xml:"writable,attr"`
-	Path           string            `json:"path" xml:"name,attr"`
-	Group          string            `json:"group"`
-	Descriptions   []Description     `xml:"desc" json:"-"`
-	DescriptionMap map[string]string `json:"descriptions"`
-	Type           string            `json:"type" xml:"type,attr"`
-}
-
-func (t Tag) CreateDescriptionMap() {
-	for _, description := range t.Descriptions {
-		t.DescriptionMap[description.Language] = description.Content
-	}
-}
-
-// getXMLAttribute returns the value of the first attribute with the given name.
-func getXMLAttribute(atts []xml.Attr, name string) *string {
-	for _, a := range atts {
-		if a.Name.Local == name {
-			return &a.Value
-		}
-	}
-	return nil
-}
-
-func closeReader(rc io.ReadCloser) {
-	err := rc.Close()
-	if err != nil {
-		log.Printf("Error closing reader: %v\n", err)
-	}
-}
-
-func handle(ctx context.Context, cancelFunc context.CancelFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			closeReader(r.Body)
-		}()
-
-		var eof bool
-		var includeSeparator bool
-		var tableName *string
-
-		w.Header().Add("Content-Type", "application/json")
-		cmd := exec.CommandContext(ctx, "exiftool", "-listx")
-		reader, err := cmd.StdoutPipe()
-
-		defer func() {
-			closeReader(reader)
-		}()
-
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Error piping content: %v\n", err)
-			return
-		}
-		err = cmd.Start()
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			log.Printf("Error starting: %v\n", err)
-			return
-		}
-		decoder := xml.NewDecoder(reader)
-		_, err = io.WriteString(w, "{\"tags\":[\n")
-
-		for !eof {
-			token, err := decoder.Token()
-			if err != nil {
-				if err != io.EOF {
-					cancelFunc()
-					log.Printf("%v\n", err)
-					return
-				}
-				_, err := w.Write(nil)
-				if err != nil {
-					log.Printf("%v\n", err)
-				}
-				eof = true
-			}
-
-			switch n := token.(type) {
-			case xml.StartElement:
-				switch n.Name.Local {
-				case "table":
-					tableName = getXMLAttribute(n.Attr, "name")
-				case "tag":
-					tag := Tag{DescriptionMap: make(map[string]string)}
-					err = decoder.DecodeElement(&tag, &n)
-					if err != nil {
-						log.Printf("Error decoding: %v\n", err)
-					}
-					if tableName != nil {
-						tag.Group = *tableName
-						tag.Path = fmt.Sprintf("%s:%s", tag.Group, tag.Path)
-					}
-					if includeSeparator {
-						_, err = io.WriteString(w, ",")
-					}
-					includeSeparator = true
-					tag.CreateDescriptionMap()
-
-					err = json.NewEncoder(w).Encode(tag)
-					if err != nil {
-						cancelFunc()
-						log.Printf("Error writing: %v\n", err)
-					}
-				}
-			default:
-			}
-		}
-		_, err = io.WriteString(w, "]}\n")
-	}
-}
-
 // exiftool needs to be installed prior running
 // port 8080 needs to be free prior running
 
-// run with go run main.go
+// gRPC needs HTTP/2, which requires TLS, so it is only served when an
+// address, certificate and key are given
+
+// run with go run .
 func main() {
+	preloadTags := flag.Bool("preload-tags", true, "load the tag database on startup rather than on the first request needing it")
+	snapshotPath := flag.String("tag-snapshot", "", "SQLite file to persist the parsed tag database to, so it survives restarts and its tags are filtered with SQL")
+	grpcAddress := flag.String("grpc-addr", "", "address to serve gRPC on, e.g. :8443")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file for the gRPC server")
+	tlsKey := flag.String("tls-key", "", "TLS key file for the gRPC server")
+	maxTimeout := flag.Duration("max-timeout", time.Minute, "maximum and default time an extraction request may take")
+	maxBodySize := flag.Int64("max-body-size", 1<<30, "maximum size in bytes of the body of an extraction request")
+	webhookSecret := flag.String("webhook-secret", "", "secret signing the webhook requests of finished jobs, which the callback parameter requires")
+	localDirs := flag.String("local-dirs", "", "directories separated like PATH whose files /metadata/local may read")
+	writableDirs := flag.String("writable-dirs", "", "directories separated like PATH whose files write jobs may modify in place")
+	outputDir := flag.String("output-dir", "", "directory write jobs write copies of server-local files to, served with signed download links")
+	downloadSecret := flag.String("download-secret", "", "secret signing the download links of written files, random if empty")
+	workers := flag.Int("exiftool-workers", 4*runtime.NumCPU(), "maximum number of exiftool commands running at once, 0 for no limit")
+	processes := flag.Int("exiftool-processes", runtime.NumCPU(), "number of long-running exiftool processes commands are passed to, 0 to start exiftool for every command")
+	remoteHostList := flag.String("remote-hosts", "", "host names separated by commas that remote files may be downloaded from and webhooks posted to, any public host if empty")
+	adminToken := flag.String("admin-token", "", "bearer token authorizing POST /admin/refresh, which is disabled if empty")
+	configDir := flag.String("config-dir", "", "directory of ExifTool config files NAME.config selected by the config parameter")
+	flag.Parse()
+
 	ctx := context.Background()
 	ctx, cancelCommand := context.WithCancel(ctx)
 
 	shutdown := make(chan os.Signal, 1)
 	serviceErrors := make(chan error, 1)
 
-	http.HandleFunc("/tags", handle(ctx, cancelCommand))
+	remoteHosts = parseRemoteHosts(*remoteHostList)
+	if *workers > 0 {
+		exiftoolWorkers = make(chan struct{}, *workers)
+	}
+	if *processes > 0 {
+		exiftoolPool = newProcessPool(ctx, *processes)
+	}
+
+	tags := &tagCache{snapshotPath: *snapshotPath}
+	if *preloadTags {
+		// Requests needing the database wait for it to be loaded.
+		go func() {
+			_, err := tags.get(ctx)
+			if err != nil {
+				log.Printf("Error loading tag database: %v\n", err)
+			}
+		}()
+	}
+	limits := extractionLimits{maxTimeout: *maxTimeout, maxSize: *maxBodySize}
+	jobs := newJobQueue(ctx, jobWorkers, *webhookSecret)
+	s3, err := newS3Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+	gcs, err := newGCSClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	azure, err := newAzureClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	sources := map[string]Source{"s3": s3, "gs": gcs, "azure": azure}
+	local, err := newLocalFiles(*localDirs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writable, err := newLocalFiles(*writableDirs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	downloads, err := newDownloads(*outputDir, *downloadSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	configs, err := newExiftoolConfigs(*configDir, tags)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.HandleFunc("/tags", withGzip(handleTags(ctx, tags, configs)))
+	http.HandleFunc("/tags/", withGzip(handleTags(ctx, tags, configs)))
+	http.HandleFunc("/tags/schema", withGzip(handleTagsSchema()))
+	http.HandleFunc("/tags/search", withGzip(handleTagSearch(ctx, tags)))
+	http.HandleFunc("/tags/stats", withGzip(handleTagStats(ctx, tags)))
+	http.HandleFunc("/tags/diff", withGzip(handleTagDiff(ctx, tags)))
+	http.HandleFunc("/tags/pseudo", withGzip(handlePseudoTags(ctx, tags)))
+	http.HandleFunc("/groups", withGzip(handleGroups(ctx, tags)))
+	http.HandleFunc("/filetypes", withGzip(handleFileTypes(ctx)))
+	http.HandleFunc("/filetypes/recognized", withGzip(handleList(ctx, "extensions", "-listr")))
+	http.HandleFunc("/filetypes/writable", withGzip(handleList(ctx, "extensions", "-listwf")))
+	http.HandleFunc("/groups/deletable", withGzip(handleList(ctx, "groups", "-listd")))
+
+	http.HandleFunc("/metadata", withGzip(withLimits(limits, handleMetadata(ctx, configs))))
+	http.HandleFunc("/metadata/binary", withLimits(limits, handleBinaryMetadata(ctx, tags, local)))
+	http.HandleFunc("/metadata/diff", withGzip(withLimits(limits, handleMetadataDiff(ctx, configs))))
+	http.HandleFunc("/metadata/batch", withGzip(withLimits(limits, handleBatchMetadata(ctx, configs))))
+	http.HandleFunc("/metadata/geo", withGzip(withLimits(limits, handleGeoMetadata(ctx))))
+	http.HandleFunc("/metadata/local", withGzip(withLimits(limits, handleLocalMetadata(ctx, local, configs))))
+	http.HandleFunc("/metadata/htmldump", withGzip(withLimits(limits, handleHTMLDump(ctx, local))))
+	http.HandleFunc("/metadata/structure", withGzip(withLimits(limits, handleStructure(ctx, local))))
+	http.HandleFunc("/metadata/xmp", withGzip(withLimits(limits, handleXMPPacket(ctx, local))))
+	http.HandleFunc("/metadata/object", withGzip(withLimits(limits, handleObjectMetadata(ctx, sources, configs))))
+	http.HandleFunc("/metadata/s3", withGzip(withLimits(limits, handleObjectMetadata(ctx, sources, configs))))
+	http.HandleFunc("/metadata/timed", withGzip(withLimits(limits, handleTimedMetadata(ctx, configs))))
+	http.HandleFunc("/metadata/track", withGzip(withLimits(limits, handleTrackMetadata(ctx))))
+	http.HandleFunc("/metadata/url", withGzip(withLimits(limits, handleURLMetadata(ctx, configs))))
+
+	http.HandleFunc("/metadata/write", withLimits(limits, handleWriteMetadata(ctx, false)))
+	http.HandleFunc("/metadata/geotag", withLimits(limits, handleWriteMetadata(ctx, true)))
+	http.HandleFunc("/metadata/scrub/gps", withLimits(limits, handleScrubGPS(ctx)))
+	http.HandleFunc("/metadata/copy", withLimits(limits, handleCopyMetadata(ctx, tags, local)))
+	http.HandleFunc("/metadata/shift", withLimits(limits, handleShiftDates(ctx)))
+	http.HandleFunc("/metadata/keywords", withLimits(limits, handleKeywords(ctx)))
+	http.HandleFunc("/metadata/rating", withLimits(limits, handleRating(ctx)))
+	http.HandleFunc("/scan", withGzip(withLimits(limits, handleScan(ctx, local, configs))))
+
+	http.HandleFunc("/downloads/", handleDownloads(downloads))
+	http.HandleFunc("/jobs", withGzip(withLimits(limits, handleJobs(jobs, configs, writable, downloads))))
+	http.HandleFunc("/jobs/", withGzip(withJobLimits(limits, handleJobs(jobs, configs, writable, downloads))))
+
+	http.HandleFunc("/validate", withGzip(withLimits(limits, handleValidate(ctx))))
+	http.HandleFunc("/identify", withGzip(withLimits(limits, handleIdentify(ctx))))
+
+	if *adminToken != "" {
+		http.HandleFunc("/admin/refresh", handleRefresh(ctx, tags, *adminToken))
+	}
 
 	server := http.Server{
 		Addr: ":8080",
 	}
+	servers := []*http.Server{&server}
 
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	go func() {
@@ -156,6 +154,21 @@ func main() {
 		serviceErrors <- server.ListenAndServe()
 	}()
 
+	if *grpcAddress != "" {
+		if *tlsCert == "" || *tlsKey == "" {
+			log.Fatal("Serving gRPC requires -tls-cert and -tls-key")
+		}
+		grpcServer := &http.Server{
+			Addr:    *grpcAddress,
+			Handler: handleGRPC(ctx, tags),
+		}
+		servers = append(servers, grpcServer)
+		go func() {
+			log.Println("Starting serving gRPC requests")
+			serviceErrors <- grpcServer.ListenAndServeTLS(*tlsCert, *tlsKey)
+		}()
+	}
+
 	select {
 	case err := <-serviceErrors:
 		cancelCommand()
@@ -166,10 +179,15 @@ func main() {
 		cancelCommand()
 		serverContext, cancelServer := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancelServer()
-		err := server.Shutdown(serverContext)
-		if err != nil {
-			log.Printf("Error shutting down web server %v", err)
-			err = server.Close()
+		for _, server := range servers {
+			shutdownErr := server.Shutdown(serverContext)
+			if shutdownErr != nil {
+				log.Printf("Error shutting down web server %v", shutdownErr)
+				shutdownErr = server.Close()
+			}
+			if shutdownErr != nil {
+				err = shutdownErr
+			}
 		}
 
 		switch {
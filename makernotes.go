@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+)
+
+// binaryValuePattern matches the placeholder exiftool writes for binary
+// values not extracted with -b.
+var binaryValuePattern = regexp.MustCompile(`^\(Binary data (\d+) bytes, use -b option to extract\)$`)
+
+// BinarySummary replaces a binary value in the metadata. The offsets of the
+// values are reported by /metadata/structure.
+type BinarySummary struct {
+	Type string      `json:"type"`
+	Size json.Number `json:"size"`
+}
+
+// summarizeBinaryValue returns the summary of the value if it is a binary
+// placeholder, and the value with its nested values summarized otherwise.
+func summarizeBinaryValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if match := binaryValuePattern.FindStringSubmatch(v); match != nil {
+			return BinarySummary{Type: "binary", Size: json.Number(match[1])}
+		}
+	case map[string]interface{}:
+		summarizeBinaryValues(v)
+	case []interface{}:
+		for i, item := range v {
+			v[i] = summarizeBinaryValue(item)
+		}
+	}
+	return value
+}
+
+// summarizeBinaryValues replaces the binary placeholders in the metadata,
+// including those nested in groups and structures, by summaries.
+func summarizeBinaryValues(metadata map[string]interface{}) {
+	for key, value := range metadata {
+		metadata[key] = summarizeBinaryValue(value)
+	}
+}
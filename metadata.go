@@ -0,0 +1,885 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metadata is the metadata exiftool extracted from one file.
+type Metadata map[string]interface{}
+
+// uploadField is the multipart form field holding uploaded files.
+const uploadField = "file"
+
+var errMissingUpload = errors.New("missing file upload")
+
+// upload is a file received from a client and stored in a temporary file.
+type upload struct {
+	name string
+	path string
+	size int64
+}
+
+func (u upload) remove() {
+	err := os.Remove(u.path)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("Error removing upload: %v\n", err)
+	}
+}
+
+// isMultipart returns whether the request body is multipart form data rather
+// than the raw content of a file.
+func isMultipart(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "multipart/form-data"
+}
+
+// saveUpload streams the first file of the multipart request into a
+// temporary file, keeping its extension so exiftool can recognize it.
+func saveUpload(r *http.Request) (upload, error) {
+	files, err := saveUploads(r, 1)
+	if err != nil {
+		return upload{}, err
+	}
+	return files[0], nil
+}
+
+// saveUploads saves the first count files of the multipart request like
+// saveUpload, failing if there are fewer.
+func saveUploads(r *http.Request, count int) ([]upload, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]upload, 0, count)
+	for len(files) < count {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			err = errMissingUpload
+		}
+		if err == nil && (part.FormName() != uploadField || part.FileName() == "") {
+			continue
+		}
+		var file upload
+		if err == nil {
+			file, err = saveFile(part, filepath.Base(part.FileName()))
+		}
+		if err != nil {
+			for _, file := range files {
+				file.remove()
+			}
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// saveFile copies the content into a temporary file with the extension of
+// the given file name.
+func saveFile(content io.Reader, name string) (upload, error) {
+	file, err := ioutil.TempFile("", "exiftool2json-*"+filepath.Ext(name))
+	if err != nil {
+		return upload{}, fmt.Errorf("error creating temporary file: %w", err)
+	}
+	saved := upload{name: name, path: file.Name()}
+	saved.size, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		saved.remove()
+		return upload{}, fmt.Errorf("error saving upload: %w", err)
+	}
+	return saved, nil
+}
+
+// decodeMetadata parses the JSON output of exiftool -j, keeping numbers as
+// they were written.
+func decodeMetadata(output []byte) ([]Metadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader(output))
+	decoder.UseNumber()
+	value, err := decodeJSONValue(decoder)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding exiftool output: %w", err)
+	}
+	files, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("error decoding exiftool output: expected an array")
+	}
+	metadata := make([]Metadata, 0, len(files))
+	for _, file := range files {
+		object, ok := file.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("error decoding exiftool output: expected an object")
+		}
+		metadata = append(metadata, object)
+	}
+	return metadata, nil
+}
+
+// decodeJSONValue decodes the next value like json.Decoder.Decode, except that
+// the values of keys occurring more than once in an object, which exiftool
+// writes for duplicate tags with -a, are collected into an array.
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := make(map[string]interface{})
+		duplicated := make(map[string]bool)
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ := keyToken.(string)
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			previous, exists := object[key]
+			switch {
+			case !exists:
+				object[key] = value
+			case duplicated[key]:
+				object[key] = append(previous.([]interface{}), value)
+			default:
+				object[key] = []interface{}{previous, value}
+				duplicated[key] = true
+			}
+		}
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err = decoder.Token()
+		return array, err
+	default:
+		return token, nil
+	}
+}
+
+// tagNamePattern matches the tag names that can be requested, optionally
+// prefixed by a group and with the wildcards exiftool supports.
+var tagNamePattern = regexp.MustCompile(`^([A-Za-z0-9_*?][A-Za-z0-9_*?-]*:)*[A-Za-z0-9_*?][A-Za-z0-9_*?-]*$`)
+
+// exiftoolOptions are the lower cased names of the exiftool options without
+// their numeric suffix, e.g. -fast2. exiftool takes a tag name without group
+// for the option of the same name.
+var exiftoolOptions = map[string]bool{
+	"a": true, "b": true, "c": true, "d": true, "e": true, "f": true, "g": true,
+	"h": true, "i": true, "j": true, "k": true, "l": true, "m": true, "n": true,
+	"o": true, "p": true, "q": true, "r": true, "s": true, "t": true, "u": true,
+	"v": true, "w": true, "x": true, "z": true,
+	"addtagsfromfile": true, "api": true, "args": true, "argformat": true,
+	"binary": true, "charset": true, "common_args": true, "config": true,
+	"coordformat": true, "csv": true, "csvdelim": true, "dateformat": true,
+	"decimal": true, "delete_original": true, "diff": true, "duplicates": true,
+	"ec": true, "echo": true, "ee": true, "efile": true, "escapehtml": true,
+	"escapexml": true, "ex": true, "exclude": true, "execute": true,
+	"ext": true, "extension": true, "extractembedded": true, "fast": true,
+	"file": true, "fileorder": true, "fixbase": true, "forceprint": true,
+	"geolocate": true, "geosync": true, "geotag": true, "globaltimeshift": true,
+	"groupheadings": true, "groupnames": true, "hex": true, "htmldump": true,
+	"htmlformat": true, "if": true, "ignore": true, "ignoreminorerrors": true,
+	"json": true, "lang": true, "latin": true, "list": true, "listd": true,
+	"listf": true, "listg": true, "listgeo": true, "listitem": true,
+	"listr": true, "listw": true, "listwf": true, "listx": true, "long": true,
+	"out": true, "overwrite_original": true, "overwrite_original_in_place": true,
+	"password": true, "pause": true, "php": true, "preserve": true,
+	"printconv": true, "printformat": true, "progress": true, "quiet": true,
+	"recurse": true, "restore_original": true, "scanforxmp": true, "sep": true,
+	"separator": true, "short": true, "sort": true, "srcfile": true,
+	"stay_open": true, "struct": true, "tab": true, "table": true,
+	"tagout": true, "tagsfromfile": true, "textout": true, "unknown": true,
+	"unknown2": true, "use": true, "userparam": true, "validate": true,
+	"ver": true, "verbose": true, "veryshort": true, "wext": true, "wm": true,
+	"writemode": true, "xmlformat": true, "zip": true,
+}
+
+// errTagDatabaseUnavailable fails the requests whose tag names cannot be
+// validated because the tag database cannot be loaded.
+var errTagDatabaseUnavailable = errors.New("tag database unavailable")
+
+// validTagName reports whether the tag can be requested. Ignoring its group
+// prefixes, it has to be a wildcard, all or a tag of the database, and
+// exiftool must not take it for an option.
+func validTagName(db *TagDatabase, tag string) bool {
+	if !tagNamePattern.MatchString(tag) {
+		return false
+	}
+	name := tag[strings.LastIndex(tag, ":")+1:]
+	if strings.ContainsAny(name, "*?") || strings.EqualFold(name, "all") {
+		return true
+	}
+	if name == tag && exiftoolOptions[strings.ToLower(strings.TrimRight(name, "0123456789"))] {
+		return false
+	}
+	return db.hasTag(name)
+}
+
+// extractionOptions are the exiftool options of an extraction requested by
+// the query parameters.
+type extractionOptions struct {
+	// config is the path of the config file defining user-defined tags
+	// loaded with -config.
+	config string
+	tags   []string
+	// groups is the -g option nesting the tags by group or the -G option
+	// prefixing their names with it, followed by the group families, e.g.
+	// -g0:1 nesting them by the family 1 groups within the family 0 ones.
+	groups string
+	// numeric disables the print conversion of values with -n.
+	numeric bool
+	// embedded is the level of the -ee option extracting the metadata of
+	// embedded documents and streams, where level 3 extracts the timed
+	// metadata of every sample of a video.
+	embedded int
+	// duplicates keeps tags with the same name with -a, whose values are
+	// returned as an array.
+	duplicates bool
+	// structured returns structures and lists as objects and arrays with
+	// -struct.
+	structured bool
+	// mwg loads the Metadata Working Group module with -use MWG, which adds
+	// the reconciled composite tags like Creator, Description and Keywords.
+	mwg bool
+	// dateFormat formats date and time values with -d, e.g. %Y-%m-%dT%H:%M:%S
+	// or %s for seconds since the epoch.
+	dateFormat string
+	// charsets are the -charset options decoding the text of metadata
+	// formats, e.g. iptc=Latin.
+	charsets []string
+	// unknown extracts unknown tags with -u, or also unknown binary tags
+	// with -U.
+	unknown string
+	// fast is the level of the -fast option, which skips reading trailers and
+	// with level 2 also maker notes.
+	fast int
+	// regions adds the XMP MWG regions, like faces, parsed from the
+	// RegionInfo structure.
+	regions bool
+	// computed adds values derived from the tags, like the megapixels or the
+	// light value.
+	computed bool
+	// normalizeDates adds the dates reconciled with their offsets from UTC.
+	normalizeDates bool
+	// fingerprint are the tags whose values are hashed into a fingerprint
+	// of the file, with which batches flag likely duplicates.
+	fingerprint []string
+	// burstWindow is the maximum time between the captures of a camera with
+	// which batches group files into bursts.
+	burstWindow time.Duration
+	// deepMakerNotes extracts every maker note tag exiftool knows, including
+	// unknown ones, summarizing binary values by their size.
+	deepMakerNotes bool
+	// excluded are the tags left out with --TAG to redact privacy-sensitive
+	// metadata.
+	excluded []string
+	// checksums are the algorithms of the digests of the files added to
+	// their metadata.
+	checksums []string
+}
+
+// redactedTags are the tags excluded by each category of privacy-sensitive
+// metadata that can be redacted.
+var redactedTags = map[string][]string{
+	"gps":       {"GPS*", "Geolocation*"},
+	"serial":    {"*SerialNumber*"},
+	"owner":     {"*Owner*"},
+	"thumbnail": {"Thumbnail*", "PreviewImage*", "JpgFromRaw*", "OtherImage*"},
+}
+
+// familyPattern matches the group families tags are grouped by, separated by
+// colons to nest them or prefix their names with several groups.
+var familyPattern = regexp.MustCompile(`^[0-7](:[0-7])*$`)
+
+// charsetTypes are the metadata formats whose character set can be given.
+var charsetTypes = map[string]bool{
+	"exif":      true,
+	"id3":       true,
+	"iptc":      true,
+	"photoshop": true,
+	"quicktime": true,
+	"riff":      true,
+}
+
+// charsetPattern matches the names of the character sets exiftool supports.
+var charsetPattern = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// writeOptionsError responds to invalid extraction options with 400, unless
+// the tag database to validate them with cannot be loaded.
+func writeOptionsError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errTagDatabaseUnavailable) {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func newExtractionOptions(ctx context.Context, query url.Values, configs *exiftoolConfigs) (extractionOptions, error) {
+	var options extractionOptions
+	var configName string
+	if value := getQueryParameter(query, "config"); value != nil {
+		config, err := configs.resolve(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid config parameter %q", *value)
+		}
+		options.config = config
+		configName = *value
+	}
+	// The tag database is only loaded for requests naming tags.
+	var db *TagDatabase
+	validTag := func(tag string) (bool, error) {
+		if db == nil {
+			var err error
+			db, err = configs.tagDatabase(ctx, configName)
+			if err != nil {
+				return false, fmt.Errorf("%w: %v", errTagDatabaseUnavailable, err)
+			}
+		}
+		return validTagName(db, tag), nil
+	}
+
+	for _, tag := range getQueryList(query, "tags") {
+		valid, err := validTag(tag)
+		if err != nil {
+			return extractionOptions{}, err
+		}
+		if !valid {
+			return extractionOptions{}, fmt.Errorf("invalid tags parameter %q", tag)
+		}
+		options.tags = append(options.tags, tag)
+	}
+
+	if value := getQueryParameter(query, "groups"); value != nil {
+		switch *value {
+		case "nested":
+			options.groups = "-g"
+		case "prefixed", "flat":
+			options.groups = "-G"
+		default:
+			return extractionOptions{}, fmt.Errorf("invalid groups parameter %q", *value)
+		}
+		family := "0"
+		if value := getQueryParameter(query, "family"); value != nil {
+			family = *value
+		}
+		if !familyPattern.MatchString(family) {
+			return extractionOptions{}, fmt.Errorf("invalid family parameter %q", family)
+		}
+		options.groups += family
+	}
+
+	if value := getQueryParameter(query, "numeric"); value != nil {
+		numeric, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid numeric parameter %q", *value)
+		}
+		options.numeric = numeric
+	}
+
+	if value := getQueryParameter(query, "embedded"); value != nil {
+		switch *value {
+		case "2", "3":
+			options.embedded, _ = strconv.Atoi(*value)
+		default:
+			embedded, err := strconv.ParseBool(*value)
+			if err != nil {
+				return extractionOptions{}, fmt.Errorf("invalid embedded parameter %q", *value)
+			}
+			if embedded {
+				options.embedded = 1
+			}
+		}
+	}
+
+	if value := getQueryParameter(query, "struct"); value != nil {
+		structured, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid struct parameter %q", *value)
+		}
+		options.structured = structured
+	}
+
+	if value := getQueryParameter(query, "mwg"); value != nil {
+		mwg, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid mwg parameter %q", *value)
+		}
+		options.mwg = mwg
+	}
+
+	if value := getQueryParameter(query, "duplicates"); value != nil {
+		duplicates, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid duplicates parameter %q", *value)
+		}
+		options.duplicates = duplicates
+	}
+
+	if value := getQueryParameter(query, "dateformat"); value != nil {
+		if *value == "" {
+			return extractionOptions{}, fmt.Errorf("invalid dateformat parameter %q", *value)
+		}
+		options.dateFormat = *value
+	}
+
+	if value := getQueryParameter(query, "unknown"); value != nil {
+		switch *value {
+		case "binary":
+			options.unknown = "-U"
+		default:
+			unknown, err := strconv.ParseBool(*value)
+			if err != nil {
+				return extractionOptions{}, fmt.Errorf("invalid unknown parameter %q", *value)
+			}
+			if unknown {
+				options.unknown = "-u"
+			}
+		}
+	}
+
+	if value := getQueryParameter(query, "regions"); value != nil {
+		regions, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid regions parameter %q", *value)
+		}
+		if regions {
+			// The regions are parsed from the structure, since the
+			// lists of their flattened fields do not line up if some
+			// regions lack a field.
+			options.regions = true
+			options.structured = true
+			if len(options.tags) > 0 {
+				options.tags = append(options.tags, "RegionInfo")
+			}
+		}
+	}
+
+	if value := getQueryParameter(query, "computed"); value != nil {
+		computed, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid computed parameter %q", *value)
+		}
+		options.computed = computed
+		if computed && len(options.tags) > 0 {
+			options.tags = append(options.tags, computedTags...)
+		}
+	}
+
+	if value := getQueryParameter(query, "normalizedates"); value != nil {
+		normalize, err := strconv.ParseBool(*value)
+		if err != nil {
+			return extractionOptions{}, fmt.Errorf("invalid normalizedates parameter %q", *value)
+		}
+		options.normalizeDates = normalize
+		if normalize && len(options.tags) > 0 {
+			options.tags = append(options.tags, normalizedDateTags...)
+		}
+	}
+
+	if value := getQueryParameter(query, "fingerprint"); value != nil {
+		// A boolean enables the default tags, anything else lists the tags.
+		if enabled, err := strconv.ParseBool(*value); err == nil {
+			if enabled {
+				options.fingerprint = defaultFingerprintTags
+			}
+		} else {
+			for _, tag := range getQueryList(query, "fingerprint") {
+				valid, err := validTag(tag)
+				if err != nil {
+					return extractionOptions{}, err
+				}
+				if !valid {
+					return extractionOptions{}, fmt.Errorf("invalid fingerprint parameter %q", tag)
+				}
+				options.fingerprint = append(options.fingerprint, tag)
+			}
+		}
+		if len(options.tags) > 0 {
+			options.tags = append(options.tags, options.fingerprint...)
+		}
+	}
+
+	if value := getQueryParameter(query, "burst"); value != nil {
+		milliseconds, err := strconv.Atoi(*value)
+		if err != nil || milliseconds <= 0 {
+			return extractionOptions{}, fmt.Errorf("invalid burst parameter %q", *value)
+		}
+		options.burstWindow = time.Duration(milliseconds) * time.Millisecond
+		if len(options.tags) > 0 {
+			options.tags = append(options.tags, burstTags...)
+		}
+	}
+
+	if value := getQueryParameter(query, "makernotes"); value != nil {
+		switch *value {
+		case "deep":
+			options.deepMakerNotes = true
+		case "standard":
+		default:
+			return extractionOptions{}, fmt.Errorf("invalid makernotes parameter %q", *value)
+		}
+	}
+
+	for _, category := range getQueryList(query, "redact") {
+		tags, ok := redactedTags[strings.ToLower(category)]
+		if !ok {
+			return extractionOptions{}, fmt.Errorf("invalid redact parameter %q", category)
+		}
+		options.excluded = append(options.excluded, tags...)
+	}
+
+	for _, algorithm := range getQueryList(query, "checksums") {
+		algorithm = strings.ToLower(algorithm)
+		if checksumAlgorithms[algorithm] == nil {
+			return extractionOptions{}, fmt.Errorf("invalid checksums parameter %q", algorithm)
+		}
+		options.checksums = append(options.checksums, algorithm)
+	}
+
+	for _, charset := range getQueryList(query, "charset") {
+		i := strings.Index(charset, ":")
+		if i < 0 || !charsetTypes[strings.ToLower(charset[:i])] || !charsetPattern.MatchString(charset[i+1:]) {
+			return extractionOptions{}, fmt.Errorf("invalid charset parameter %q", charset)
+		}
+		options.charsets = append(options.charsets, strings.ToLower(charset[:i])+"="+charset[i+1:])
+	}
+	return options, nil
+}
+
+// args returns the exiftool arguments selecting the options.
+func (options extractionOptions) args() []string {
+	var args []string
+	if options.config != "" {
+		// -config has to be the first argument.
+		args = append(args, "-config", options.config)
+	}
+	args = append(args, "-j")
+	if options.groups != "" {
+		args = append(args, options.groups)
+	}
+	if options.numeric {
+		args = append(args, "-n")
+	}
+	switch options.embedded {
+	case 0:
+	case 1:
+		args = append(args, "-ee")
+	default:
+		args = append(args, "-ee"+strconv.Itoa(options.embedded))
+	}
+	if options.duplicates {
+		args = append(args, "-a")
+	}
+	if options.structured {
+		args = append(args, "-struct")
+	}
+	if options.mwg {
+		args = append(args, "-use", "MWG")
+	}
+	if options.dateFormat != "" {
+		args = append(args, "-d", options.dateFormat)
+	}
+	for _, charset := range options.charsets {
+		args = append(args, "-charset", charset)
+	}
+	switch {
+	case options.deepMakerNotes:
+		args = append(args, "-U", "-api", "RequestAll=3")
+	case options.unknown != "":
+		args = append(args, options.unknown)
+	}
+	switch options.fast {
+	case 0:
+	case 1:
+		args = append(args, "-fast")
+	default:
+		args = append(args, "-fast"+strconv.Itoa(options.fast))
+	}
+	for _, tag := range options.tags {
+		args = append(args, "-"+tag)
+	}
+	for _, tag := range options.excluded {
+		args = append(args, "--"+tag)
+	}
+	return args
+}
+
+// process post-processes the metadata extracted with the options.
+func (options extractionOptions) process(metadata ...Metadata) {
+	for _, m := range metadata {
+		if options.deepMakerNotes {
+			summarizeBinaryValues(m)
+		}
+		if options.regions {
+			addRegions(m)
+		}
+		if options.computed {
+			m[computedKey] = computeValues(m)
+		}
+		if options.normalizeDates {
+			m[normalizedDatesKey] = normalizeDates(m)
+		}
+		if len(options.fingerprint) > 0 {
+			addFingerprint(m, options.fingerprint)
+		}
+	}
+}
+
+// runExtraction runs exiftool with the arguments, which have to include -j,
+// and decodes the metadata it prints.
+func runExtraction(ctx context.Context, stdin io.Reader, args ...string) ([]Metadata, error) {
+	output, runErr := runExiftool(ctx, stdin, args...)
+	if len(bytes.TrimSpace(output)) == 0 {
+		if runErr == nil {
+			runErr = errors.New("exiftool returned no metadata")
+		}
+		return nil, runErr
+	}
+	return decodeMetadata(output)
+}
+
+// extractMetadata runs exiftool -j on the files and returns their metadata in
+// the same order.
+func extractMetadata(ctx context.Context, options extractionOptions, paths ...string) ([]Metadata, error) {
+	metadata, err := runExtraction(ctx, nil, append(options.args(), paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	options.process(metadata...)
+	return metadata, nil
+}
+
+// extractStream runs exiftool -j on the file read from the reader. With -fast
+// exiftool stops reading once it found the metadata, so large files do not
+// need to be transferred completely.
+func extractStream(ctx context.Context, options extractionOptions, content io.Reader) ([]Metadata, error) {
+	if options.fast == 0 {
+		options.fast = 1
+	}
+	var sums *checksums
+	if len(options.checksums) > 0 {
+		sums = newChecksums(options.checksums)
+		content = io.TeeReader(content, sums)
+	}
+	metadata, err := runExtraction(ctx, content, append(options.args(), "-")...)
+	if err != nil {
+		return nil, err
+	}
+	options.process(metadata...)
+	if sums == nil {
+		return metadata, nil
+	}
+
+	// The checksums are computed of what exiftool read and of the rest it
+	// skipped.
+	_, err = io.Copy(ioutil.Discard, content)
+	if err != nil {
+		return nil, fmt.Errorf("error computing checksums: %w", err)
+	}
+	if len(metadata) > 0 {
+		metadata[0][checksumKey] = sums.values()
+	}
+	return metadata, nil
+}
+
+// streamMetadata runs exiftool -j on the files like extractMetadata, but
+// passes the metadata of every file to visit as soon as exiftool printed it.
+// The standard input can provide further arguments with -@ -.
+func streamMetadata(ctx context.Context, options extractionOptions, stdin io.Reader, visit func(Metadata) error, paths ...string) error {
+	release, err := acquireWorker(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "exiftool", append(options.args(), paths...)...)
+	cmd.Stdin = stdin
+	cmd.Stderr = &stderr
+	reader, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error piping content: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("error starting: %w", err)
+	}
+
+	count, decodeErr := decodeMetadataStream(reader, func(metadata Metadata) error {
+		options.process(metadata)
+		return visit(metadata)
+	})
+	if decodeErr != nil {
+		err := cmd.Process.Kill()
+		if err != nil {
+			log.Printf("Error killing exiftool: %v\n", err)
+		}
+	}
+	err = cmd.Wait()
+	switch {
+	case decodeErr != nil:
+		return decodeErr
+	case err != nil && count == 0:
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return fmt.Errorf("error running exiftool: %s", message)
+	}
+	return nil
+}
+
+// decodeMetadataStream decodes the output of exiftool -j while it is being
+// written, passing every file to visit, and returns the number of files.
+func decodeMetadataStream(reader io.Reader, visit func(Metadata) error) (int, error) {
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	token, err := decoder.Token()
+	if err == io.EOF {
+		return 0, nil
+	}
+	if err != nil || token != json.Delim('[') {
+		return 0, errors.New("error decoding exiftool output: expected an array")
+	}
+	count := 0
+	for decoder.More() {
+		value, err := decodeJSONValue(decoder)
+		if err != nil {
+			return count, fmt.Errorf("error decoding exiftool output: %w", err)
+		}
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return count, errors.New("error decoding exiftool output: expected an object")
+		}
+		count++
+		err = visit(object)
+		if err != nil {
+			return count, err
+		}
+	}
+	_, err = decoder.Token()
+	if err != nil {
+		return count, fmt.Errorf("error decoding exiftool output: %w", err)
+	}
+	return count, nil
+}
+
+// handleMetadata extracts the metadata of a file uploaded as multipart form
+// data in the file field, or else sent as the raw request body, which is
+// streamed to exiftool without storing it.
+func handleMetadata(ctx context.Context, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, metadata)
+	}
+}
+
+// extractRequestFile extracts the metadata of the file uploaded as multipart
+// form data or sent as the raw request body. If it fails, the error is
+// written to the client and false is returned.
+func extractRequestFile(ctx context.Context, w http.ResponseWriter, r *http.Request, options extractionOptions) (Metadata, bool) {
+	if isMultipart(r) {
+		file, err := saveUpload(r)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusBadRequest, err)
+			return nil, false
+		}
+		defer file.remove()
+		return extractFile(ctx, w, r, options, file)
+	}
+
+	name := "-"
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		name = filepath.Base(params["filename"])
+	}
+	commandCtx, cancel := commandContext(ctx, r)
+	defer cancel()
+	metadata, err := extractStream(commandCtx, options, r.Body)
+	return singleMetadata(w, r, metadata, err, name)
+}
+
+// extractFile extracts the metadata of the file like extractRequestFile.
+func extractFile(ctx context.Context, w http.ResponseWriter, r *http.Request, options extractionOptions, file upload) (Metadata, bool) {
+	commandCtx, cancel := commandContext(ctx, r)
+	defer cancel()
+	metadata, err := extractMetadata(commandCtx, options, file.path)
+	if err == nil && len(metadata) > 0 {
+		err = addFileChecksums(metadata[0], options, file.path)
+	}
+	return singleMetadata(w, r, metadata, err, file.name)
+}
+
+// serveMetadata writes the metadata extracted from the file, reporting the
+// name the client knows it by as its source file.
+func serveMetadata(ctx context.Context, w http.ResponseWriter, r *http.Request, options extractionOptions, file upload) {
+	metadata, ok := extractFile(ctx, w, r, options, file)
+	if !ok {
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	writeJSON(w, r, metadata)
+}
+
+// singleMetadata returns the metadata extracted from a single file with the
+// given source file name, or writes the error to the client.
+func singleMetadata(w http.ResponseWriter, r *http.Request, metadata []Metadata, err error, name string) (Metadata, bool) {
+	if err != nil {
+		writeExtractionError(w, r, http.StatusInternalServerError, err)
+		return nil, false
+	}
+	if len(metadata) == 0 {
+		http.Error(w, "no metadata extracted", http.StatusUnprocessableEntity)
+		return nil, false
+	}
+	metadata[0]["SourceFile"] = name
+	return metadata[0], true
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestExtractionOptionsFingerprint(t *testing.T) {
+	db := &TagDatabase{names: map[string]bool{"make": true, "model": true}}
+	configs := &exiftoolConfigs{defaults: &tagCache{db: db}}
+	tests := []struct {
+		value       string
+		fingerprint []string
+	}{
+		{"true", defaultFingerprintTags},
+		{"1", defaultFingerprintTags},
+		{"T", defaultFingerprintTags},
+		{"false", nil},
+		{"0", nil},
+		{"Make,Model", []string{"Make", "Model"}},
+	}
+	for _, test := range tests {
+		options, err := newExtractionOptions(context.Background(), url.Values{"fingerprint": {test.value}}, configs)
+		if err != nil {
+			t.Errorf("fingerprint=%s: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(options.fingerprint, test.fingerprint) {
+			t.Errorf("fingerprint=%s hashes %q, want %q", test.value, options.fingerprint, test.fingerprint)
+		}
+	}
+
+	_, err := newExtractionOptions(context.Background(), url.Values{"fingerprint": {"Unknown"}}, configs)
+	if err == nil {
+		t.Error("fingerprint=Unknown was accepted")
+	}
+}
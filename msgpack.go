@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// appendMsgpack appends the MessagePack encoding of a value decoded from JSON
+// with numbers kept as json.Number, see https://github.com/msgpack/msgpack/blob/master/spec.md.
+func appendMsgpack(b []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return appendMsgpackInt(b, i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, 0xcb)
+		return appendUint64(b, math.Float64bits(f)), nil
+	case string:
+		return appendMsgpackString(b, v), nil
+	case []interface{}:
+		b = appendMsgpackLength(b, len(v), 0x90, 0xdc, 0xdd)
+		var err error
+		for _, item := range v {
+			b, err = appendMsgpack(b, item)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = appendMsgpackLength(b, len(v), 0x80, 0xde, 0xdf)
+		var err error
+		for _, key := range keys {
+			b = appendMsgpackString(b, key)
+			b, err = appendMsgpack(b, v[key])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	default:
+		return nil, fmt.Errorf("cannot encode %T as MessagePack", value)
+	}
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendMsgpackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i < 128:
+		return append(b, byte(i))
+	case i < 0 && i >= -32:
+		return append(b, byte(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(int32(i)))
+		return append(append(b, 0xd2), buf[:]...)
+	default:
+		return appendUint64(append(b, 0xd3), uint64(i))
+	}
+}
+
+// appendMsgpackLength appends the header of a string, array or map using the
+// fixed format for short lengths and the 16 or 32 bit format otherwise.
+func appendMsgpackLength(b []byte, length int, fixed byte, format16 byte, format32 byte) []byte {
+	switch {
+	case fixed != 0xa0 && length < 16, fixed == 0xa0 && length < 32:
+		return append(b, fixed|byte(length))
+	case length <= math.MaxUint16:
+		return append(b, format16, byte(length>>8), byte(length))
+	default:
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], uint32(length))
+		return append(append(b, format32), buf[:]...)
+	}
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	if len(s) >= 32 && len(s) <= math.MaxUint8 {
+		b = append(b, 0xd9, byte(len(s)))
+	} else {
+		b = appendMsgpackLength(b, len(s), 0xa0, 0xda, 0xdb)
+	}
+	return append(b, s...)
+}
+
+// marshalMsgpack encodes a value via its JSON encoding as MessagePack.
+func marshalMsgpack(value interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var generic interface{}
+	err = decoder.Decode(&generic)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, generic)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// formatMediaTypes maps the media types clients may accept to the output
+// formats of the /tags endpoint.
+var formatMediaTypes = map[string]string{
+	"application/json":               "json",
+	"application/x-ndjson":           "ndjson",
+	"application/ndjson":             "ndjson",
+	"text/csv":                       "csv",
+	"application/xml":                "xml",
+	"text/xml":                       "xml",
+	"application/x-protobuf":         "protobuf",
+	"application/protobuf":           "protobuf",
+	"application/msgpack":            "msgpack",
+	"application/x-msgpack":          "msgpack",
+	"application/vnd.apache.parquet": "parquet",
+	"application/*":                  "json",
+	"*/*":                            "json",
+}
+
+type acceptedMediaType struct {
+	mediaType string
+	quality   float64
+}
+
+// parseAccept returns the media types of the Accept header ordered by
+// preference, leaving out those with a quality of zero.
+func parseAccept(header string) []acceptedMediaType {
+	var accepted []acceptedMediaType
+	for _, entry := range strings.Split(header, ",") {
+		parts := strings.Split(entry, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(parts[0]))
+		if mediaType == "" {
+			continue
+		}
+		quality := 1.0
+		for _, parameter := range parts[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				if q, err := strconv.ParseFloat(parameter[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedMediaType{mediaType, quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+	return accepted
+}
+
+// negotiateFormat selects the output format from the format query parameter,
+// falling back to the Accept header. It reports false if the client accepts
+// none of the supported formats.
+func negotiateFormat(r *http.Request) (string, bool) {
+	if value := getQueryParameter(r.URL.Query(), "format"); value != nil {
+		return *value, true
+	}
+	header := r.Header.Get("Accept")
+	if header == "" {
+		return "json", true
+	}
+	accepted := parseAccept(header)
+	for _, preferred := range accepted {
+		if preferred.quality < accepted[0].quality {
+			break
+		}
+		if format, ok := formatMediaTypes[preferred.mediaType]; ok {
+			return format, true
+		}
+	}
+	// Browsers prefer text/html and accept application/xml and */* with a
+	// lower quality, for which JSON suits better than the XML they rank
+	// before it.
+	for _, fallback := range accepted {
+		if format, ok := formatMediaTypes[fallback.mediaType]; ok && strings.HasSuffix(fallback.mediaType, "/*") {
+			return format, true
+		}
+	}
+	for _, fallback := range accepted {
+		if format, ok := formatMediaTypes[fallback.mediaType]; ok {
+			return format, true
+		}
+	}
+	return "", false
+}
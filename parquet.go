@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Thrift compact protocol types used by the Parquet metadata, see
+// https://github.com/apache/thrift/blob/master/doc/specs/thrift-compact-protocol.md.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// Parquet enum values, see https://github.com/apache/parquet-format/blob/master/src/main/thrift/parquet.thrift.
+const (
+	parquetBoolean   = 0
+	parquetByteArray = 6
+
+	parquetRequired = 0
+	parquetUTF8     = 0
+
+	parquetPlain        = 0
+	parquetRLE          = 3
+	parquetUncompressed = 0
+	parquetDataPage     = 0
+)
+
+// thriftWriter writes structs in the Thrift compact protocol.
+type thriftWriter struct {
+	b       []byte
+	fieldID []int16
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.b = appendVarint(t.b, v)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftWriter) fieldHeader(id int16, fieldType byte) {
+	last := &t.fieldID[len(t.fieldID)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|fieldType)
+	} else {
+		t.b = append(t.b, fieldType)
+		t.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) beginStruct() {
+	t.fieldID = append(t.fieldID, 0)
+}
+
+func (t *thriftWriter) endStruct() {
+	t.b = append(t.b, 0)
+	t.fieldID = t.fieldID[:len(t.fieldID)-1]
+}
+
+func (t *thriftWriter) i32Field(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64Field(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) stringField(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.varint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func (t *thriftWriter) structField(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.beginStruct()
+}
+
+func (t *thriftWriter) listField(id int16, elementType byte, size int) {
+	t.fieldHeader(id, thriftList)
+	if size < 15 {
+		t.b = append(t.b, byte(size)<<4|elementType)
+	} else {
+		t.b = append(t.b, 0xf0|elementType)
+		t.varint(uint64(size))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) listString(s string) {
+	t.varint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+// parquetColumn is a required column of the Parquet export, holding either
+// strings or, if boolean is set, booleans.
+type parquetColumn struct {
+	name    string
+	value   func(tag Tag) string
+	boolean func(tag Tag) bool
+}
+
+var parquetColumns = []parquetColumn{
+	{name: "path", value: func(tag Tag) string { return tag.Path }},
+	{name: "group", value: func(tag Tag) string { return tag.Group }},
+	{name: "name", value: func(tag Tag) string { return tag.Name() }},
+	{name: "id", value: func(tag Tag) string { return tag.ID }},
+	{name: "type", value: func(tag Tag) string { return tag.Type }},
+	{name: "count", value: func(tag Tag) string { return tag.Count }},
+	{name: "writable", boolean: func(tag Tag) bool { return tag.Writable }},
+	{name: "g0", value: func(tag Tag) string { return tag.Family0 }},
+	{name: "g1", value: func(tag Tag) string { return tag.Family1 }},
+	{name: "g2", value: func(tag Tag) string { return tag.Family2 }},
+	{name: "description", value: func(tag Tag) string { return tag.Description("en") }},
+}
+
+// plainValues encodes the column values of the tags with the PLAIN encoding.
+func (c parquetColumn) plainValues(tags []Tag) []byte {
+	if c.boolean != nil {
+		values := make([]byte, (len(tags)+7)/8)
+		for i, tag := range tags {
+			if c.boolean(tag) {
+				values[i/8] |= 1 << uint(i%8)
+			}
+		}
+		return values
+	}
+	var values []byte
+	var length [4]byte
+	for _, tag := range tags {
+		value := c.value(tag)
+		binary.LittleEndian.PutUint32(length[:], uint32(len(value)))
+		values = append(values, length[:]...)
+		values = append(values, value...)
+	}
+	return values
+}
+
+// writeParquet writes the tags as a Parquet file with a single row group of
+// uncompressed, PLAIN encoded required columns.
+func writeParquet(w io.Writer, tags []Tag) error {
+	file := []byte("PAR1")
+	type chunk struct {
+		offset int64
+		size   int64
+	}
+	chunks := make([]chunk, len(parquetColumns))
+
+	for i, column := range parquetColumns {
+		values := column.plainValues(tags)
+
+		header := &thriftWriter{}
+		header.beginStruct()
+		header.i32Field(1, parquetDataPage)
+		header.i32Field(2, int32(len(values)))
+		header.i32Field(3, int32(len(values)))
+		header.structField(5)
+		header.i32Field(1, int32(len(tags)))
+		header.i32Field(2, parquetPlain)
+		header.i32Field(3, parquetRLE)
+		header.i32Field(4, parquetRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunks[i] = chunk{offset: int64(len(file)), size: int64(len(header.b) + len(values))}
+		file = append(file, header.b...)
+		file = append(file, values...)
+	}
+
+	var totalSize int64
+	for _, c := range chunks {
+		totalSize += c.size
+	}
+
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32Field(1, 1)
+	meta.listField(2, thriftStruct, len(parquetColumns)+1)
+	meta.beginStruct()
+	meta.stringField(4, "schema")
+	meta.i32Field(5, int32(len(parquetColumns)))
+	meta.endStruct()
+	for _, column := range parquetColumns {
+		meta.beginStruct()
+		if column.boolean != nil {
+			meta.i32Field(1, parquetBoolean)
+		} else {
+			meta.i32Field(1, parquetByteArray)
+		}
+		meta.i32Field(3, parquetRequired)
+		meta.stringField(4, column.name)
+		if column.boolean == nil {
+			meta.i32Field(6, parquetUTF8)
+		}
+		meta.endStruct()
+	}
+	meta.i64Field(3, int64(len(tags)))
+	meta.listField(4, thriftStruct, 1)
+	meta.beginStruct()
+	meta.listField(1, thriftStruct, len(parquetColumns))
+	for i, column := range parquetColumns {
+		meta.beginStruct()
+		meta.i64Field(2, chunks[i].offset)
+		meta.structField(3)
+		if column.boolean != nil {
+			meta.i32Field(1, parquetBoolean)
+		} else {
+			meta.i32Field(1, parquetByteArray)
+		}
+		meta.listField(2, thriftI32, 2)
+		meta.listI32(parquetPlain)
+		meta.listI32(parquetRLE)
+		meta.listField(3, thriftBinary, 1)
+		meta.listString(column.name)
+		meta.i32Field(4, parquetUncompressed)
+		meta.i64Field(5, int64(len(tags)))
+		meta.i64Field(6, chunks[i].size)
+		meta.i64Field(7, chunks[i].size)
+		meta.i64Field(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.i64Field(2, totalSize)
+	meta.i64Field(3, int64(len(tags)))
+	meta.endStruct()
+	meta.stringField(6, "exiftool2json")
+	meta.endStruct()
+
+	file = append(file, meta.b...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(meta.b)))
+	file = append(file, length[:]...)
+	file = append(file, "PAR1"...)
+
+	_, err := w.Write(file)
+	return err
+}
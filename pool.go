@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// exiftoolPool is the pool runExiftool runs commands with, if not nil. It is
+// set once on startup.
+var exiftoolPool *processPool
+
+var errProcessBroken = errors.New("exiftool process broken")
+
+// exiftoolProcess is a long-lived exiftool -stay_open True -@ - process,
+// which reads the arguments of its commands from the standard input. Each
+// command ends with -execute and a number, which exiftool echoes once it is
+// done, so the output of the commands can be told apart.
+type exiftoolProcess struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   *bufio.Reader
+	stderr   *bufio.Reader
+	commands int
+}
+
+func startExiftoolProcess(ctx context.Context) (*exiftoolProcess, error) {
+	cmd := exec.CommandContext(ctx, "exiftool", "-stay_open", "True", "-@", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error piping arguments: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error piping output: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("error piping errors: %w", err)
+	}
+	err = cmd.Start()
+	if err != nil {
+		return nil, fmt.Errorf("error starting: %w", err)
+	}
+	return &exiftoolProcess{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), stderr: bufio.NewReader(stderr)}, nil
+}
+
+// readUntil reads the output of a command up to the line exiftool echoes
+// when it is done, which is returned without the output.
+func readUntil(reader *bufio.Reader, marker string) ([]byte, string, error) {
+	var output bytes.Buffer
+	for {
+		line, err := reader.ReadBytes('\n')
+		if i := bytes.Index(line, []byte(marker)); i >= 0 && bytes.HasSuffix(line, []byte("}\n")) {
+			output.Write(line[:i])
+			return output.Bytes(), string(bytes.TrimSpace(line[i:])), nil
+		}
+		output.Write(line)
+		if err != nil {
+			return output.Bytes(), "", err
+		}
+	}
+}
+
+// execute runs a command and returns its standard output, its standard
+// error and its exit status. It fails with errProcessBroken if the process
+// cannot be used any more.
+func (p *exiftoolProcess) execute(args []string) ([]byte, []byte, int, error) {
+	p.commands++
+	marker := "{ready" + strconv.Itoa(p.commands)
+	var command bytes.Buffer
+	for _, arg := range args {
+		command.WriteString(arg)
+		command.WriteByte('\n')
+	}
+	// -echo4 writes to the standard error after the command is done, with
+	// its exit status.
+	fmt.Fprintf(&command, "-echo4\n%s ${status}}\n-execute%d\n", marker, p.commands)
+	_, err := p.stdin.Write(command.Bytes())
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %v", errProcessBroken, err)
+	}
+
+	type result struct {
+		output []byte
+		ready  string
+		err    error
+	}
+	stderr := make(chan result, 1)
+	go func() {
+		output, ready, err := readUntil(p.stderr, marker+" ")
+		stderr <- result{output, ready, err}
+	}()
+	stdout, _, err := readUntil(p.stdout, marker+"}")
+	errorOutput := <-stderr
+	if err == nil {
+		err = errorOutput.err
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("%w: %v", errProcessBroken, err)
+	}
+	status, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(errorOutput.ready, marker+" "), "}"))
+	if err != nil {
+		// Older versions of exiftool echo no status, so an error message
+		// is taken as a failure.
+		status = 0
+		if bytes.Contains(errorOutput.output, []byte("Error")) {
+			status = 1
+		}
+	}
+	return stdout, errorOutput.output, status, nil
+}
+
+// kill ends the process, which is no longer usable, once the output of the
+// running command has been read.
+func (p *exiftoolProcess) kill(done <-chan struct{}) {
+	err := p.cmd.Process.Kill()
+	if err != nil {
+		log.Printf("Error killing exiftool: %v\n", err)
+	}
+	<-done
+	err = p.stdin.Close()
+	if err != nil {
+		log.Printf("Error closing exiftool arguments: %v\n", err)
+	}
+	// The process was killed, so it exits with an error.
+	_ = p.cmd.Wait()
+}
+
+// processPool keeps up to size exiftool processes running to avoid the
+// startup time of Perl and the loading of the modules on every command.
+//
+// Only commands run to completion without standard input use the pool: the
+// standard input of a process carries the arguments, so a file cannot be
+// piped to it, and the output of a command is only told apart from the next
+// one by the line exiftool echoes when it is done, so a client that stops
+// reading streamed output would leave the process unusable. These commands
+// start their own exiftool process instead.
+type processPool struct {
+	ctx   context.Context
+	idle  chan *exiftoolProcess
+	slots chan struct{}
+}
+
+// newProcessPool returns a pool of at most size processes, which are started
+// when needed and end with the context.
+func newProcessPool(ctx context.Context, size int) *processPool {
+	return &processPool{
+		ctx:   ctx,
+		idle:  make(chan *exiftoolProcess, size),
+		slots: make(chan struct{}, size),
+	}
+}
+
+// processOptions are the lower cased options that change the state of an
+// exiftool process for the commands after the one they were passed to, e.g.
+// -use MWG adding the MWG composite tags.
+var processOptions = map[string]bool{
+	"-api":         true,
+	"-common_args": true,
+	"-stay_open":   true,
+	"-use":         true,
+	"-userparam":   true,
+}
+
+// pooled returns whether the arguments can be passed to a process of the
+// pool. The argument file of a process has one argument per line, ignoring
+// white space at the beginning and lines starting with #, the config file
+// can only be loaded on startup, and the processOptions would affect the
+// commands run by the process later.
+func pooled(args []string) bool {
+	for i, arg := range args {
+		switch {
+		case arg == "" || strings.ContainsAny(arg, "\r\n") || strings.TrimLeft(arg, " \t") != arg:
+			return false
+		case strings.HasPrefix(arg, "#"):
+			return false
+		case i == 0 && strings.EqualFold(arg, "-config"):
+			return false
+		case processOptions[strings.ToLower(arg)] || arg == "-@":
+			return false
+		}
+	}
+	return true
+}
+
+// run runs exiftool with the arguments in a process of the pool like
+// runExiftool, waiting for one to become available.
+func (pool *processPool) run(ctx context.Context, args []string) ([]byte, error) {
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() {
+		<-pool.slots
+	}()
+
+	var p *exiftoolProcess
+	select {
+	case p = <-pool.idle:
+	default:
+		var err error
+		p, err = startExiftoolProcess(pool.ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	type result struct {
+		stdout []byte
+		stderr []byte
+		status int
+		err    error
+	}
+	results := make(chan result, 1)
+	done := make(chan struct{})
+	go func() {
+		stdout, stderr, status, err := p.execute(args)
+		results <- result{stdout, stderr, status, err}
+		close(done)
+	}()
+	var r result
+	select {
+	case r = <-results:
+	case <-ctx.Done():
+		// The command cannot be interrupted, so the process is.
+		p.kill(done)
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		p.kill(done)
+		return nil, r.err
+	}
+	pool.idle <- p
+
+	if r.status != 0 {
+		message := strings.TrimSpace(string(r.stderr))
+		if message == "" {
+			message = fmt.Sprintf("exit status %d", r.status)
+		}
+		return r.stdout, fmt.Errorf("error running exiftool: %s", message)
+	}
+	return r.stdout, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeExiftoolEnv makes the test binary behave like exiftool -stay_open True
+// -@ -, so the pool can be tested without exiftool installed.
+const fakeExiftoolEnv = "EXIFTOOL2JSON_FAKE_EXIFTOOL"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeExiftoolEnv) != "" {
+		fakeExiftool()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeExiftool prints the arguments of every command to the standard output.
+// A command with -Fail fails with an error message and one with -Hang never
+// finishes.
+func fakeExiftool() {
+	if strings.Join(os.Args[1:], " ") != "-stay_open True -@ -" {
+		fmt.Fprintf(os.Stderr, "unexpected arguments %q\n", os.Args[1:])
+		os.Exit(2)
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	var args []string
+	echo := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "-echo4":
+			scanner.Scan()
+			echo = scanner.Text()
+		case strings.HasPrefix(line, "-execute"):
+			status := "0"
+			switch {
+			case contains(args, "-Hang"):
+				select {}
+			case contains(args, "-Fail"):
+				status = "1"
+				fmt.Fprintln(os.Stderr, "Error: failed")
+			default:
+				fmt.Println(strings.Join(args, " "))
+			}
+			fmt.Printf("{ready%s}\n", strings.TrimPrefix(line, "-execute"))
+			fmt.Fprintln(os.Stderr, strings.Replace(echo, "${status}", status, 1))
+			args = nil
+		default:
+			args = append(args, line)
+		}
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// withFakeExiftool puts the test binary first in the PATH as exiftool.
+func withFakeExiftool(t *testing.T) func() {
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "exiftool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(executable, filepath.Join(dir, "exiftool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	_ = os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	_ = os.Setenv(fakeExiftoolEnv, "1")
+	return func() {
+		_ = os.Setenv("PATH", path)
+		_ = os.Unsetenv(fakeExiftoolEnv)
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func TestPooled(t *testing.T) {
+	tests := []struct {
+		args   []string
+		pooled bool
+	}{
+		{[]string{"-j", "-G", "image.jpg"}, true},
+		{[]string{"-ver"}, true},
+		{[]string{"-j", ""}, false},
+		{[]string{"-j", "line\nbreak.jpg"}, false},
+		{[]string{"-j", "carriage\rreturn.jpg"}, false},
+		{[]string{"-j", " leading.jpg"}, false},
+		{[]string{"-j", "#comment.jpg"}, false},
+		{[]string{"-config", "user.config", "-j", "image.jpg"}, false},
+		{[]string{"-j", "-config", "image.jpg"}, true},
+		{[]string{"-stay_open", "False"}, false},
+		{[]string{"-@", "args.txt"}, false},
+		{[]string{"-j", "-use", "MWG", "image.jpg"}, false},
+		{[]string{"-j", "-U", "-api", "RequestAll=3", "image.jpg"}, false},
+		{[]string{"-j", "-userParam", "name=value", "image.jpg"}, false},
+		{[]string{"-j", "-API", "LargeFileSupport", "image.jpg"}, false},
+	}
+	for _, test := range tests {
+		if pooled(test.args) != test.pooled {
+			t.Errorf("pooled(%q) = %v, want %v", test.args, !test.pooled, test.pooled)
+		}
+	}
+}
+
+func TestReadUntil(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("[{\"a\": 1}]\nlast{ready1}\nnext\n"))
+	output, ready, err := readUntil(reader, "{ready1}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(output) != "[{\"a\": 1}]\nlast" || ready != "{ready1}" {
+		t.Errorf("readUntil = %q, %q", output, ready)
+	}
+	rest, _ := ioutil.ReadAll(reader)
+	if string(rest) != "next\n" {
+		t.Errorf("read past the marker, left %q", rest)
+	}
+
+	// The marker has to end its line.
+	reader = bufio.NewReader(strings.NewReader("{ready1} not done\n"))
+	output, _, err = readUntil(reader, "{ready1}")
+	if err != io.EOF || string(output) != "{ready1} not done\n" {
+		t.Errorf("readUntil = %q, %v, want the output and io.EOF", output, err)
+	}
+}
+
+func TestExecute(t *testing.T) {
+	defer withFakeExiftool(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := startExiftoolProcess(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = p.stdin.Close()
+		_ = p.cmd.Wait()
+	}()
+
+	for i := 1; i <= 2; i++ {
+		stdout, stderr, status, err := p.execute([]string{"-j", fmt.Sprintf("image%d.jpg", i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("-j image%d.jpg\n", i); string(stdout) != want || len(stderr) != 0 || status != 0 {
+			t.Errorf("execute = %q, %q, %d, want %q", stdout, stderr, status, want)
+		}
+	}
+
+	stdout, stderr, status, err := p.execute([]string{"-Fail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stdout) != 0 || !bytes.Contains(stderr, []byte("Error: failed")) || status != 1 {
+		t.Errorf("execute = %q, %q, %d, want a failure", stdout, stderr, status)
+	}
+}
+
+func TestProcessPoolRun(t *testing.T) {
+	defer withFakeExiftool(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pool := newProcessPool(ctx, 1)
+
+	output, err := pool.run(ctx, []string{"-ver"})
+	if err != nil || string(output) != "-ver\n" {
+		t.Fatalf("run = %q, %v", output, err)
+	}
+	_, err = pool.run(ctx, []string{"-Fail"})
+	if err == nil || !strings.Contains(err.Error(), "Error: failed") {
+		t.Errorf("run failed with %v, want the error message of exiftool", err)
+	}
+	if len(pool.idle) != 1 {
+		t.Fatalf("%d idle processes, want the process to be reused", len(pool.idle))
+	}
+
+	// A cancelled command kills its process, which the next command replaces.
+	commandCtx, cancelCommand := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelCommand()
+	_, err = pool.run(commandCtx, []string{"-Hang"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("run failed with %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(pool.idle) != 0 {
+		t.Errorf("%d idle processes, want the hanging process to be dropped", len(pool.idle))
+	}
+	output, err = pool.run(ctx, []string{"-j", "image.jpg"})
+	if err != nil || string(output) != "-j image.jpg\n" {
+		t.Errorf("run = %q, %v", output, err)
+	}
+}
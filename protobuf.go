@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Protocol buffer wire types, see https://protobuf.dev/programming-guides/encoding/.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errTruncatedMessage = errors.New("truncated protocol buffer message")
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func consumeVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		v |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, -1
+}
+
+func appendKey(b []byte, field int, wireType int) []byte {
+	return appendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// appendStringField appends a proto3 string field, leaving out the default value.
+func appendStringField(b []byte, field int, value string) []byte {
+	if value == "" {
+		return b
+	}
+	return appendBytesField(b, field, []byte(value))
+}
+
+// appendBoolField appends a proto3 bool field, leaving out the default value.
+func appendBoolField(b []byte, field int, value bool) []byte {
+	if !value {
+		return b
+	}
+	b = appendKey(b, field, wireVarint)
+	return append(b, 1)
+}
+
+// appendStringMapField appends a map<string, string> field with sorted keys.
+func appendStringMapField(b []byte, field int, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = appendStringField(entry, 1, key)
+		entry = appendStringField(entry, 2, m[key])
+		b = appendBytesField(b, field, entry)
+	}
+	return b
+}
+
+// protoField is a field read from an encoded message. Varint fields carry
+// their value in varint, length delimited fields in bytes.
+type protoField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// decodeProtoFields calls visit for every field of the encoded message.
+func decodeProtoFields(b []byte, visit func(field protoField) error) error {
+	for len(b) > 0 {
+		key, n := consumeVarint(b)
+		if n < 0 {
+			return errTruncatedMessage
+		}
+		b = b[n:]
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = consumeVarint(b)
+			if n < 0 {
+				return errTruncatedMessage
+			}
+		case wireBytes:
+			length, m := consumeVarint(b)
+			if m < 0 || uint64(len(b)-m) < length {
+				return errTruncatedMessage
+			}
+			field.bytes = b[m : m+int(length)]
+			n = m + int(length)
+		case wireFixed64:
+			n = 8
+		case wireFixed32:
+			n = 4
+		default:
+			return fmt.Errorf("unsupported wire type %d", field.wireType)
+		}
+		if len(b) < n {
+			return errTruncatedMessage
+		}
+		b = b[n:]
+		err := visit(field)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalProto encodes the tag as the Tag message of tags.proto. The
+// description and label maps are expected to be filled.
+func (t Tag) MarshalProto() []byte {
+	var b []byte
+	b = appendStringField(b, 1, t.ID)
+	b = appendStringField(b, 2, t.Count)
+	b = appendBoolField(b, 3, t.Writable)
+	b = appendStringField(b, 4, t.Path)
+	b = appendStringField(b, 5, t.Group)
+
+	var families []byte
+	families = appendStringField(families, 1, t.Family0)
+	families = appendStringField(families, 2, t.Family1)
+	families = appendStringField(families, 3, t.Family2)
+	b = appendBytesField(b, 6, families)
+
+	b = appendStringMapField(b, 7, t.DescriptionMap)
+	b = appendStringField(b, 8, t.Type)
+
+	var flags []byte
+	flags = appendBoolField(flags, 1, t.Flags.Avoid)
+	flags = appendBoolField(flags, 2, t.Flags.Binary)
+	flags = appendBoolField(flags, 3, t.Flags.Protected)
+	flags = appendBoolField(flags, 4, t.Flags.Permanent)
+	flags = appendBoolField(flags, 5, t.Flags.Unsafe)
+	flags = appendBoolField(flags, 6, t.Flags.Mandatory)
+	flags = appendBoolField(flags, 7, t.Flags.List)
+	b = appendBytesField(b, 9, flags)
+
+	for _, value := range t.Values {
+		var v []byte
+		v = appendStringField(v, 1, value.Key)
+		v = appendStringMapField(v, 2, value.LabelMap)
+		b = appendBytesField(b, 10, v)
+	}
+	return b
+}
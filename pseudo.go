@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// isPseudoTag reports whether the tag is a writable pseudo tag, which is no
+// metadata in the file but a property of it in the file system, like
+// FileName, Directory or FileModifyDate.
+func isPseudoTag(tag Tag) bool {
+	return tag.Writable && tag.Family0 == "File" && tag.Family1 == "System"
+}
+
+// handlePseudoTags serves the names of the writable pseudo tags of the tag
+// database as a JSON object with the names under the tags key.
+func handlePseudoTags(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		if notModified(w, r, db.ETag) {
+			return
+		}
+
+		names := make([]string, 0)
+		seen := make(map[string]bool)
+		for _, tag := range db.Tags {
+			if isPseudoTag(tag) && !seen[tag.Name()] {
+				seen[tag.Name()] = true
+				names = append(names, tag.Name())
+			}
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, map[string][]string{"tags": names})
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// getQueryParameter returns the value of the query parameter with the given name,
+// or nil if it was not provided.
+func getQueryParameter(query url.Values, name string) *string {
+	if values, ok := query[name]; ok && len(values) > 0 {
+		return &values[0]
+	}
+	return nil
+}
+
+// getQueryList returns the non-empty comma separated values of the query
+// parameter with the given name.
+func getQueryList(query url.Values, name string) []string {
+	var list []string
+	for _, value := range query[name] {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+// getLanguages returns the set of description languages selected with the
+// lang query parameter. An empty set selects all languages.
+func getLanguages(query url.Values) map[string]bool {
+	languages := make(map[string]bool)
+	for _, language := range getQueryList(query, "lang") {
+		languages[language] = true
+	}
+	return languages
+}
+
+// tagFilter narrows down the tags returned by the /tags endpoint.
+type tagFilter struct {
+	group     *string
+	writable  *bool
+	valueType *string
+	query     *string
+	composite *bool
+	flags     []string
+	filter    expression
+	pathRegex *regexp.Regexp
+}
+
+func newTagFilter(query url.Values) (tagFilter, error) {
+	filter := tagFilter{
+		group:     getQueryParameter(query, "group"),
+		valueType: getQueryParameter(query, "type"),
+		flags:     getQueryList(query, "flags"),
+	}
+	if value := getQueryParameter(query, "q"); value != nil {
+		search := strings.ToLower(*value)
+		filter.query = &search
+	}
+	if value := getQueryParameter(query, "writable"); value != nil {
+		writable, err := strconv.ParseBool(*value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid writable parameter %q", *value)
+		}
+		filter.writable = &writable
+	}
+	if value := getQueryParameter(query, "composite"); value != nil {
+		composite, err := strconv.ParseBool(*value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid composite parameter %q", *value)
+		}
+		filter.composite = &composite
+	}
+	if value := getQueryParameter(query, "filter"); value != nil {
+		expr, err := compileExpression(*value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid filter parameter: %w", err)
+		}
+		filter.filter = expr
+	}
+	if value := getQueryParameter(query, "path_regex"); value != nil {
+		pathRegex, err := regexp.Compile(*value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid path_regex parameter: %w", err)
+		}
+		filter.pathRegex = pathRegex
+	}
+	return filter, nil
+}
+
+func (f tagFilter) matches(tag Tag) bool {
+	if f.group != nil && tag.Group != *f.group && tag.Family1 != *f.group {
+		return false
+	}
+	if f.writable != nil && tag.Writable != *f.writable {
+		return false
+	}
+	if f.valueType != nil && tag.Type != *f.valueType {
+		return false
+	}
+	if f.query != nil && !tag.contains(*f.query) {
+		return false
+	}
+	if f.composite != nil && tag.IsComposite() != *f.composite {
+		return false
+	}
+	for _, flag := range f.flags {
+		if !tag.Flags.Has(flag) {
+			return false
+		}
+	}
+	if f.pathRegex != nil && !f.pathRegex.MatchString(tag.Path) {
+		return false
+	}
+	if f.filter != nil {
+		// tags the expression cannot be evaluated against do not match
+		matched, err := f.filter.matches(tag)
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// pagination selects the window of matching tags written by the /tags endpoint.
+type pagination struct {
+	offset int
+	limit  *int
+}
+
+func newPagination(query url.Values) (pagination, error) {
+	var page pagination
+	if value := getQueryParameter(query, "offset"); value != nil {
+		offset, err := strconv.Atoi(*value)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("invalid offset parameter %q", *value)
+		}
+		page.offset = offset
+	}
+	if value := getQueryParameter(query, "limit"); value != nil {
+		limit, err := strconv.Atoi(*value)
+		if err != nil || limit < 0 {
+			return page, fmt.Errorf("invalid limit parameter %q", *value)
+		}
+		page.limit = &limit
+	}
+	return page, nil
+}
+
+// includes reports whether the match at the given index falls inside the page.
+func (p pagination) includes(index int) bool {
+	if index < p.offset {
+		return false
+	}
+	return p.limit == nil || index < p.offset+*p.limit
+}
+
+// tagOrder sorts the tags returned by the /tags endpoint.
+type tagOrder struct {
+	field      string
+	descending bool
+}
+
+// newTagOrder returns the order selected with the sort and order query
+// parameters, or nil if the tags should be kept in the order of exiftool.
+func newTagOrder(query url.Values) (*tagOrder, error) {
+	field := getQueryParameter(query, "sort")
+	if field == nil {
+		return nil, nil
+	}
+	switch *field {
+	case "path", "group", "type":
+	default:
+		return nil, fmt.Errorf("invalid sort parameter %q", *field)
+	}
+
+	order := &tagOrder{field: *field}
+	if value := getQueryParameter(query, "order"); value != nil {
+		switch *value {
+		case "asc":
+		case "desc":
+			order.descending = true
+		default:
+			return nil, fmt.Errorf("invalid order parameter %q", *value)
+		}
+	}
+	return order, nil
+}
+
+func (o tagOrder) key(tag Tag) string {
+	switch o.field {
+	case "group":
+		return tag.Group
+	case "type":
+		return tag.Type
+	default:
+		return tag.Path
+	}
+}
+
+// sort orders the tags by the selected field, falling back to the path to
+// keep the output deterministic.
+func (o tagOrder) sort(tags []Tag) {
+	sort.SliceStable(tags, func(i, j int) bool {
+		a, b := o.key(tags[i]), o.key(tags[j])
+		if a == b {
+			a, b = tags[i].Path, tags[j].Path
+		}
+		if o.descending {
+			return a > b
+		}
+		return a < b
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// handleRating writes the XMP rating and label of a file uploaded as
+// multipart form data or sent as the raw request body and returns the
+// modified file, e.g. while culling photos. The rating parameter is 1 to 5
+// stars, 0 for unrated or -1 for rejected, and the label parameter is a
+// color label like Red. Empty parameters delete the values. With dryrun=true,
+// the changes are returned instead.
+func handleRating(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		options, err := newWriteOptions(query)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var args []string
+		if value := getQueryParameter(query, "rating"); value != nil {
+			if *value != "" {
+				rating, err := strconv.Atoi(*value)
+				if err != nil || rating < -1 || rating > 5 {
+					http.Error(w, fmt.Sprintf("invalid rating parameter %q", *value), http.StatusBadRequest)
+					return
+				}
+			}
+			args = append(args, "-XMP-xmp:Rating="+*value)
+		}
+		if value := getQueryParameter(query, "label"); value != nil {
+			args = append(args, "-XMP-xmp:Label="+*value)
+		}
+		if len(args) == 0 {
+			http.Error(w, "missing rating or label parameter", http.StatusBadRequest)
+			return
+		}
+
+		file, ok := saveRequestFile(w, r)
+		if !ok {
+			return
+		}
+		defer file.remove()
+
+		writeFile(ctx, w, r, options, file, args...)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// regionsKey is the key of the regions added to the extracted metadata.
+const regionsKey = "Regions"
+
+// Region is an area of an image described by an XMP MWG region, like a
+// face, with coordinates relative to the width and height of the image.
+type Region struct {
+	Name        string  `json:"name,omitempty"`
+	Type        string  `json:"type,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Left        float64 `json:"left"`
+	Top         float64 `json:"top"`
+	Width       float64 `json:"width"`
+	Height      float64 `json:"height"`
+}
+
+// findTag returns the value of the tag, which may be prefixed with its group
+// or nested in a group object. If several groups have the tag, the value of
+// the first group in alphabetical order is returned.
+func findTag(metadata map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := metadata[name]; ok {
+		return value, true
+	}
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if strings.HasSuffix(key, ":"+name) {
+			return metadata[key], true
+		}
+	}
+	for _, key := range keys {
+		if group, ok := metadata[key].(map[string]interface{}); ok {
+			if value, ok := group[name]; ok {
+				return value, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// mwgRegions parses the regions of the RegionInfo structure extracted with
+// -struct. The areas given by their center are converted to their top left
+// corner, and pixel areas are normalized by the applied dimensions.
+func mwgRegions(info map[string]interface{}) []Region {
+	regions := make([]Region, 0)
+	var width, height float64
+	if dimensions, ok := info["AppliedToDimensions"].(map[string]interface{}); ok {
+		width, _ = jsonFloat(dimensions["W"])
+		height, _ = jsonFloat(dimensions["H"])
+	}
+	list, _ := info["RegionList"].([]interface{})
+	for _, item := range list {
+		region, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		area, ok := region["Area"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		x, xErr := jsonFloat(area["X"])
+		y, yErr := jsonFloat(area["Y"])
+		w, wErr := jsonFloat(area["W"])
+		h, hErr := jsonFloat(area["H"])
+		if xErr != nil || yErr != nil || wErr != nil || hErr != nil {
+			continue
+		}
+		if unit, _ := area["Unit"].(string); unit == "pixel" {
+			if width == 0 || height == 0 {
+				continue
+			}
+			x, w = x/width, w/width
+			y, h = y/height, h/height
+		}
+		parsed := Region{Left: x - w/2, Top: y - h/2, Width: w, Height: h}
+		parsed.Name, _ = region["Name"].(string)
+		parsed.Type, _ = region["Type"].(string)
+		parsed.Description, _ = region["Description"].(string)
+		regions = append(regions, parsed)
+	}
+	return regions
+}
+
+// addRegions adds the regions of the image to its metadata.
+func addRegions(metadata Metadata) {
+	value, ok := findTag(metadata, "RegionInfo")
+	if !ok {
+		return
+	}
+	if info, ok := value.(map[string]interface{}); ok {
+		metadata[regionsKey] = mwgRegions(info)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Limits of the downloads of remote files to extract metadata from.
+const (
+	maxDownloadSize = 512 << 20
+	downloadTimeout = 2 * time.Minute
+)
+
+// maxURLRequestSize limits the size of the JSON body naming the remote file.
+const maxURLRequestSize = 64 << 10
+
+var errDownloadTooLarge = fmt.Errorf("remote file exceeds %d bytes", maxDownloadSize)
+
+var errRemoteAddressNotAllowed = errors.New("address is not public")
+
+// downloadClient downloads from the configured cloud storage services.
+var downloadClient = &http.Client{Timeout: downloadTimeout}
+
+// remoteClient downloads from the URLs given by clients, which must not reach
+// the server itself or its private network.
+var remoteClient = newRemoteClient(downloadTimeout)
+
+// remoteHosts are the host names remote URLs are restricted to, any public
+// host if empty.
+var remoteHosts map[string]bool
+
+// reservedNetworks are the non-public IPv4 networks the net.IP methods do
+// not cover: the shared address space of RFC 6598 used by carrier-grade NAT
+// and "this network" of RFC 1122.
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"),
+	mustParseCIDR("0.0.0.0/8"),
+}
+
+// nat64Prefix is the well-known NAT64 prefix of RFC 6052, whose addresses
+// reach the IPv4 address in their last 4 bytes.
+var nat64Prefix = mustParseCIDR("64:ff9b::/96")
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// parseRemoteHosts parses the host names separated by commas of the
+// -remote-hosts flag.
+func parseRemoteHosts(value string) map[string]bool {
+	if value == "" {
+		return nil
+	}
+	hosts := make(map[string]bool)
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts[strings.ToLower(host)] = true
+		}
+	}
+	return hosts
+}
+
+// isPublicIP reports whether the address is neither loopback, private,
+// link-local, unspecified, multicast nor reserved. IPv4-mapped and NAT64
+// IPv6 addresses are checked by the IPv4 address they reach.
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	} else if nat64Prefix.Contains(ip) {
+		ip = ip[net.IPv6len-net.IPv4len:]
+	}
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkRemoteAddress rejects connections to non-public addresses. It is
+// called after the host name has been resolved, so host names resolving to
+// a private address cannot get around it.
+func checkRemoteAddress(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return errRemoteAddressNotAllowed
+	}
+	return nil
+}
+
+// newRemoteClient returns a client connecting to public addresses only,
+// checking every redirect like the URL it was given.
+func newRemoteClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: checkRemoteAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect on behalf of the client, bypassing the check.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkRemoteURL(request.URL)
+		},
+	}
+}
+
+// checkRemoteURL validates that the URL is an absolute http or https URL of
+// one of the remote hosts, if restricted, and not of a non-public address.
+func checkRemoteURL(location *url.URL) error {
+	if (location.Scheme != "http" && location.Scheme != "https") || location.Host == "" {
+		return fmt.Errorf("invalid url %q", location)
+	}
+	host := strings.ToLower(location.Hostname())
+	if len(remoteHosts) > 0 && !remoteHosts[host] {
+		return fmt.Errorf("host %q is not allowed", host)
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return fmt.Errorf("host %q is not allowed", host)
+	}
+	return nil
+}
+
+// URLRequest is the body of POST /metadata/url.
+type URLRequest struct {
+	URL string `json:"url"`
+}
+
+// parseDownloadURL validates that the URL is an absolute http or https URL
+// the server may connect to.
+func parseDownloadURL(rawURL string) (*url.URL, error) {
+	location, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %q", rawURL)
+	}
+	err = checkRemoteURL(location)
+	if err != nil {
+		return nil, err
+	}
+	return location, nil
+}
+
+// download saves the remote file into a temporary file, failing if it is
+// larger than maxDownloadSize.
+func download(ctx context.Context, location *url.URL) (upload, error) {
+	request, err := http.NewRequest(http.MethodGet, location.String(), nil)
+	if err != nil {
+		return upload{}, err
+	}
+	response, err := remoteClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return upload{}, fmt.Errorf("error downloading %s: %w", location, err)
+	}
+	defer func() {
+		closeReader(response.Body)
+	}()
+	if response.StatusCode != http.StatusOK {
+		return upload{}, fmt.Errorf("error downloading %s: %s", location, response.Status)
+	}
+	if response.ContentLength > maxDownloadSize {
+		return upload{}, errDownloadTooLarge
+	}
+
+	name := path.Base(location.Path)
+	if name == "/" || name == "." {
+		name = location.Host
+	}
+	file, err := saveFile(io.LimitReader(response.Body, maxDownloadSize+1), name)
+	if err != nil {
+		return upload{}, err
+	}
+	if file.size > maxDownloadSize {
+		file.remove()
+		return upload{}, errDownloadTooLarge
+	}
+	file.name = location.String()
+	return file, nil
+}
+
+// handleURLMetadata downloads the file at the URL given in the JSON body and
+// extracts its metadata.
+func handleURLMetadata(ctx context.Context, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+
+		var request URLRequest
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxURLRequestSize)).Decode(&request)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		location, err := parseDownloadURL(request.URL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		file, err := download(commandCtx, location)
+		if errors.Is(err, errRemoteAddressNotAllowed) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeExtractionError(w, r, http.StatusBadGateway, err)
+			return
+		}
+		defer file.remove()
+
+		serveMetadata(ctx, w, r, options, file)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of the empty body of a GET request.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3BucketPattern matches valid S3 bucket names.
+var s3BucketPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// s3Client reads objects from S3 or a compatible service, configured with
+// the standard AWS environment variables. Requests are signed with
+// Signature Version 4 if credentials are given and anonymous otherwise.
+type s3Client struct {
+	region       string
+	endpoint     *url.URL
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+func newS3Client() (*s3Client, error) {
+	client := &s3Client{
+		region:       os.Getenv("AWS_REGION"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if client.region == "" {
+		client.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if client.region == "" {
+		client.region = "us-east-1"
+	}
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL_S3"); endpoint != "" {
+		var err error
+		client.endpoint, err = url.Parse(endpoint)
+		if err != nil || client.endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL_S3 %q", endpoint)
+		}
+	}
+	return client, nil
+}
+
+// s3EscapePath escapes the object path as required by Signature Version 4,
+// keeping the slashes.
+func s3EscapePath(path string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(path) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// objectURL returns the URL of the object, using path style requests for
+// custom endpoints and virtual hosted style requests for AWS.
+func (client *s3Client) objectURL(bucket string, key string) (*url.URL, error) {
+	if !s3BucketPattern.MatchString(bucket) || key == "" {
+		return nil, errInvalidObject
+	}
+	if client.endpoint != nil {
+		location := *client.endpoint
+		location.Path = strings.TrimSuffix(location.Path, "/") + "/" + bucket + "/" + key
+		location.RawPath = s3EscapePath(location.Path)
+		return &location, nil
+	}
+	path := "/" + key
+	return &url.URL{
+		Scheme:  "https",
+		Host:    bucket + ".s3." + client.region + ".amazonaws.com",
+		Path:    path,
+		RawPath: s3EscapePath(path),
+	}, nil
+}
+
+// authorize signs the request if credentials are configured.
+func (client *s3Client) authorize(ctx context.Context, request *http.Request) error {
+	if client.accessKey != "" {
+		client.sign(request, time.Now())
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sign adds the Signature Version 4 authorization of the GET request.
+func (client *s3Client) sign(request *http.Request, now time.Time) {
+	date := now.UTC().Format("20060102T150405Z")
+	day := date[:8]
+	request.Header.Set("X-Amz-Date", date)
+	request.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if client.sessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", client.sessionToken)
+	}
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + request.URL.Host + "\nx-amz-content-sha256:" + emptyPayloadHash + "\nx-amz-date:" + date + "\n"
+	if client.sessionToken != "" {
+		signedHeaders += ";x-amz-security-token"
+		canonicalHeaders += "x-amz-security-token:" + client.sessionToken + "\n"
+	}
+	canonicalRequest := strings.Join([]string{
+		request.Method,
+		request.URL.EscapedPath(),
+		request.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+	hashedRequest := sha256.Sum256([]byte(canonicalRequest))
+	scope := day + "/" + client.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + date + "\n" + scope + "\n" + hex.EncodeToString(hashedRequest[:])
+
+	key := hmacSHA256([]byte("AWS4"+client.secretKey), day)
+	key = hmacSHA256(key, client.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	request.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		client.accessKey, scope, signedHeaders, signature))
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxScanRequestSize limits the size of the JSON body of POST /scan.
+const maxScanRequestSize = 64 << 10
+
+// ScanRequest is the body of POST /scan. The glob patterns are matched
+// against the file name, or against the path relative to the scanned
+// directory if they contain a slash.
+type ScanRequest struct {
+	Path    string   `json:"path"`
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// matchesAny returns whether the path relative to the scanned directory
+// matches any of the patterns.
+func matchesAny(patterns []string, relative string) bool {
+	for _, pattern := range patterns {
+		name := filepath.Base(relative)
+		if strings.Contains(pattern, "/") {
+			name = filepath.ToSlash(relative)
+		}
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// recognizedExtensions returns the lower cased file extensions exiftool
+// recognizes, including the dot.
+func recognizedExtensions(ctx context.Context) (map[string]bool, error) {
+	return listExtensions(ctx, "-listr")
+}
+
+// writableExtensions returns the extensions of the files exiftool can write
+// like recognizedExtensions.
+func writableExtensions(ctx context.Context) (map[string]bool, error) {
+	return listExtensions(ctx, "-listwf")
+}
+
+// listExtensions returns the extensions listed by the exiftool option,
+// including the leading dot and in lower case.
+func listExtensions(ctx context.Context, option string) (map[string]bool, error) {
+	output, err := runExiftool(ctx, nil, option)
+	if err != nil {
+		return nil, err
+	}
+	names, err := decodeList(bytes.NewReader(output))
+	if err != nil {
+		return nil, err
+	}
+	extensions := make(map[string]bool, len(names))
+	for _, name := range names {
+		extensions["."+strings.ToLower(name)] = true
+	}
+	return extensions, nil
+}
+
+// scanFiles walks the directory and returns the regular files with a
+// recognized extension selected by the patterns, mapped to the paths below
+// the directory. Symbolic links are skipped, so the scan cannot leave the
+// allowed directories. Files whose path contains a line break are skipped
+// too, as exiftool reads the paths one per line with -@.
+func scanFiles(root string, request ScanRequest, extensions map[string]bool) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if relative != "." && matchesAny(request.Exclude, relative) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || !extensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		if strings.ContainsAny(path, "\r\n") {
+			return nil
+		}
+		if len(request.Include) > 0 && !matchesAny(request.Include, relative) {
+			return nil
+		}
+		if matchesAny(request.Exclude, relative) {
+			return nil
+		}
+		files[path] = filepath.Join(request.Path, relative)
+		return nil
+	})
+	return files, err
+}
+
+// handleScan extracts the metadata of every recognized file below a
+// directory within the allowed directories. The results are streamed as a
+// JSON array while exiftool processes the files.
+func handleScan(ctx context.Context, local *localFiles, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+
+		var request ScanRequest
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxScanRequestSize)).Decode(&request)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err = validatePatterns(request.Include); err == nil {
+			err = validatePatterns(request.Exclude)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		root, err := local.resolveDir(request.Path)
+		switch {
+		case errors.Is(err, errPathNotAllowed):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		extensions, err := recognizedExtensions(commandCtx)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		files, err := scanFiles(root, request, extensions)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusInternalServerError, fmt.Errorf("error scanning %s: %w", root, err))
+			return
+		}
+
+		paths := make([]string, 0, len(files))
+		for path := range files {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		var arguments bytes.Buffer
+		for _, path := range paths {
+			arguments.WriteString(path)
+			arguments.WriteByte('\n')
+		}
+		flusher, _ := w.(http.Flusher)
+		written := 0
+		w.Header().Add("Content-Type", "application/json")
+		if len(files) > 0 {
+			err = streamMetadata(commandCtx, options, &arguments, func(metadata Metadata) error {
+				if source, ok := metadata["SourceFile"].(string); ok {
+					err := addFileChecksums(metadata, options, source)
+					if err != nil {
+						return err
+					}
+					metadata["SourceFile"] = files[source]
+				}
+				separator := ","
+				if written == 0 {
+					separator = "["
+				}
+				written++
+				encoded, err := json.Marshal(metadata)
+				if err != nil {
+					return err
+				}
+				_, err = fmt.Fprintf(w, "%s%s", separator, encoded)
+				if err == nil && flusher != nil {
+					flusher.Flush()
+				}
+				return err
+			}, "-@", "-")
+		}
+		switch {
+		case err != nil && written == 0:
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		case err != nil:
+			// The status has been sent, so the truncated array tells the
+			// client that the scan failed.
+			log.Printf("Error scanning %s: %v\n", root, err)
+			return
+		case written == 0:
+			_, err = w.Write([]byte("[]"))
+		default:
+			_, err = w.Write([]byte("]"))
+		}
+		if err != nil {
+			log.Printf("Error writing: %v\n", err)
+		}
+	}
+}
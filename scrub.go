@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// gpsScrubArgs delete the location of a file.
+var gpsScrubArgs = []string{"-gps:all=", "-xmp:geotag="}
+
+// handleScrubGPS removes the location from a file uploaded as multipart form
+// data or sent as the raw request body, keeping all other metadata like the
+// camera settings, and returns the scrubbed file. With dryrun=true, the
+// changes are returned instead.
+func handleScrubGPS(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newWriteOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		file, ok := saveRequestFile(w, r)
+		if !ok {
+			return
+		}
+		defer file.remove()
+
+		writeFile(ctx, w, r, options, file, gpsScrubArgs...)
+	}
+}
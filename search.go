@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Weights of the places a term occurs in and of how well it matches.
+const (
+	nameWeight        = 2.0
+	descriptionWeight = 1.0
+	exactMatch        = 3.0
+	prefixMatch       = 2.0
+	fuzzyMatch        = 1.0
+)
+
+// defaultSearchLimit is the number of results returned by /tags/search
+// unless a limit is given.
+const defaultSearchLimit = 20
+
+type posting struct {
+	tag    int
+	weight float64
+}
+
+// searchIndex is an inverted index over the tag names and descriptions in all
+// languages, supporting typo tolerant lookups.
+type searchIndex struct {
+	postings map[string][]posting
+}
+
+// tokenize splits text into lower cased terms, also splitting camel cased
+// tag names like ExposureTime into their words.
+func tokenize(text string) []string {
+	var terms []string
+	var current []rune
+	flush := func() {
+		if len(current) > 0 {
+			terms = append(terms, strings.ToLower(string(current)))
+			current = current[:0]
+		}
+	}
+	runes := []rune(text)
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if i > 0 && unicode.IsUpper(r) && len(current) > 0 &&
+			(unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]) && unicode.IsUpper(runes[i-1]))) {
+			flush()
+		}
+		current = append(current, r)
+	}
+	flush()
+	return terms
+}
+
+func newSearchIndex(tags []Tag) *searchIndex {
+	index := &searchIndex{postings: make(map[string][]posting)}
+	for i, tag := range tags {
+		weights := make(map[string]float64)
+		name := tag.Name()
+		weights[strings.ToLower(name)] = nameWeight
+		for _, term := range tokenize(name) {
+			weights[term] = nameWeight
+		}
+		for _, description := range tag.Descriptions {
+			for _, term := range tokenize(description.Content) {
+				if weights[term] < descriptionWeight {
+					weights[term] = descriptionWeight
+				}
+			}
+		}
+		for term, weight := range weights {
+			index.postings[term] = append(index.postings[term], posting{tag: i, weight: weight})
+		}
+	}
+	return index
+}
+
+// maxEdits is the number of typos tolerated in a query term of the given length.
+func maxEdits(length int) int {
+	switch {
+	case length <= 3:
+		return 0
+	case length <= 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance returns the Levenshtein distance between a and b, or a value
+// above limit as soon as it is known to exceed it.
+func editDistance(a []rune, b []rune, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		best := current[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j-1] + cost
+			if v := previous[j] + 1; v < current[j] {
+				current[j] = v
+			}
+			if v := current[j-1] + 1; v < current[j] {
+				current[j] = v
+			}
+			if current[j] < best {
+				best = current[j]
+			}
+		}
+		if best > limit {
+			return limit + 1
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// SearchResult is a tag matching a search with its relevance score.
+type SearchResult struct {
+	Score float64 `json:"score"`
+	Tag   Tag     `json:"tag"`
+}
+
+// search returns the tags matching the query ranked by relevance. Every query
+// term contributes the best of its exact, prefix and fuzzy matches.
+func (index *searchIndex) search(tags []Tag, query string) []SearchResult {
+	scores := make(map[int]float64)
+	for _, term := range tokenize(query) {
+		runes := []rune(term)
+		edits := maxEdits(len(runes))
+		best := make(map[int]float64)
+		for candidate, postings := range index.postings {
+			var match float64
+			switch {
+			case candidate == term:
+				match = exactMatch
+			case strings.HasPrefix(candidate, term):
+				match = prefixMatch
+			case edits > 0 && editDistance(runes, []rune(candidate), edits) <= edits:
+				match = fuzzyMatch
+			default:
+				continue
+			}
+			for _, p := range postings {
+				if score := match * p.weight; score > best[p.tag] {
+					best[p.tag] = score
+				}
+			}
+		}
+		for tag, score := range best {
+			scores[tag] += score
+		}
+	}
+
+	results := make([]SearchResult, 0, len(scores))
+	for i, score := range scores {
+		results = append(results, SearchResult{Score: score, Tag: tags[i]})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Tag.Path < results[j].Tag.Path
+	})
+	return results
+}
+
+func handleTagSearch(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		query := getQueryParameter(r.URL.Query(), "q")
+		if query == nil || strings.TrimSpace(*query) == "" {
+			http.Error(w, "missing q parameter", http.StatusBadRequest)
+			return
+		}
+		limit := defaultSearchLimit
+		if value := getQueryParameter(r.URL.Query(), "limit"); value != nil {
+			var err error
+			limit, err = strconv.Atoi(*value)
+			if err != nil || limit < 0 {
+				http.Error(w, "invalid limit parameter "+strconv.Quote(*value), http.StatusBadRequest)
+				return
+			}
+		}
+		languages := getLanguages(r.URL.Query())
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+
+		results := db.index.search(db.Tags, *query)
+		total := len(results)
+		if len(results) > limit {
+			results = results[:limit]
+		}
+		for i := range results {
+			results[i].Tag.CreateDescriptionMap(languages)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, struct {
+			Results []SearchResult `json:"results"`
+			Total   int            `json:"total"`
+		}{results, total})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// dateShiftPattern matches the shifts exiftool applies to dates, which are
+// hours, or hours, minutes and seconds, optionally preceded by years, months
+// and days, e.g. -1:30:00 or +0:0:1 2:00:00.
+var dateShiftPattern = regexp.MustCompile(`^[+-]?(\d+:\d+:\d+ )?\d+(:\d+(:\d+(\.\d+)?)?)?$`)
+
+// dateShiftArgs returns the exiftool arguments shifting the tags, AllDates
+// if none are given, by the shift.
+func dateShiftArgs(shift string, tags []string) []string {
+	operator := "+="
+	if strings.HasPrefix(shift, "-") {
+		operator = "-="
+	}
+	shift = strings.TrimLeft(shift, "+-")
+	if len(tags) == 0 {
+		tags = []string{"AllDates"}
+	}
+	args := make([]string, len(tags))
+	for i, tag := range tags {
+		args[i] = "-" + tag + operator + shift
+	}
+	return args
+}
+
+// handleShiftDates shifts the dates of a file uploaded as multipart form data
+// or sent as the raw request body by the shift parameter, e.g. to fix the
+// wrong clock of a camera, and returns the modified file. The tags parameter
+// selects the date tags to shift instead of DateTimeOriginal, CreateDate and
+// ModifyDate. With dryrun=true, the changes are returned instead.
+func handleShiftDates(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newWriteOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		shift := getQueryParameter(r.URL.Query(), "shift")
+		if shift == nil || *shift == "" {
+			http.Error(w, "missing shift parameter", http.StatusBadRequest)
+			return
+		}
+		if !dateShiftPattern.MatchString(*shift) {
+			http.Error(w, fmt.Sprintf("invalid shift parameter %q", *shift), http.StatusBadRequest)
+			return
+		}
+		tags := getQueryList(r.URL.Query(), "tags")
+		for _, tag := range tags {
+			if !tagNamePattern.MatchString(tag) || strings.ContainsAny(tag, "*?") {
+				http.Error(w, fmt.Sprintf("invalid tags parameter %q", tag), http.StatusBadRequest)
+				return
+			}
+		}
+
+		file, ok := saveRequestFile(w, r)
+		if !ok {
+			return
+		}
+		defer file.remove()
+
+		writeFile(ctx, w, r, options, file, dateShiftArgs(*shift, tags)...)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Namings of XMP sidecars.
+const (
+	// sidecarReplace replaces the extension of the file, e.g. IMG_1.xmp as
+	// used by Lightroom.
+	sidecarReplace = "replace"
+	// sidecarAppend appends the extension to the name of the file, e.g.
+	// IMG_1.CR2.xmp as used by darktable.
+	sidecarAppend = "append"
+)
+
+// sidecarName returns the name of the XMP sidecar of the file.
+func sidecarName(name string, naming string) string {
+	if naming == sidecarReplace {
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return name + ".xmp"
+}
+
+// isSidecar returns whether the file is an XMP sidecar itself.
+func isSidecar(name string) bool {
+	return strings.EqualFold(filepath.Ext(name), ".xmp")
+}
+
+// sidecarArgs returns the exiftool arguments writing the sidecar of the
+// file, which copy the metadata of the file to the sidecar, creating it if
+// it does not exist, followed by the values to write.
+func sidecarArgs(file upload, args []string) []string {
+	return append([]string{"-tagsFromFile", file.path}, args...)
+}
+
+// withoutSidecars returns the files that are no XMP sidecars, whose sidecars
+// would be the files themselves.
+func withoutSidecars(files []upload) []upload {
+	var kept []upload
+	for _, file := range files {
+		if !isSidecar(file.name) {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// writtenSidecars returns the sidecars of the files written successfully
+// according to the results of writeFiles, named like the sidecars of the
+// files in the archive. Files sharing a sidecar are listed once.
+func writtenSidecars(files []upload, results []WriteResult, naming string) []upload {
+	var sidecars []upload
+	seen := make(map[string]bool)
+	for i, file := range files {
+		if !results[i].Written || seen[results[i].SourceFile] {
+			continue
+		}
+		seen[results[i].SourceFile] = true
+		sidecars = append(sidecars, upload{name: results[i].SourceFile, path: sidecarName(file.path, naming)})
+	}
+	return sidecars
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+var (
+	errInvalidObject  = errors.New("invalid bucket or key")
+	errObjectNotFound = errors.New("object not found")
+	errObjectDenied   = errors.New("access to object denied")
+)
+
+// Source is a cloud storage service objects can be read from.
+type Source interface {
+	// objectURL returns the URL to download the object from, failing with
+	// errInvalidObject if the bucket or key is invalid.
+	objectURL(bucket string, key string) (*url.URL, error)
+	// authorize adds the credentials of the service to a request.
+	authorize(ctx context.Context, request *http.Request) error
+}
+
+// openObject starts downloading the object at the URL, authorizing the
+// request with the source unless it is nil, e.g. for presigned URLs, which
+// are downloaded like remote files. The caller has to close the returned
+// body.
+func openObject(ctx context.Context, source Source, location *url.URL) (io.ReadCloser, error) {
+	request, err := http.NewRequest(http.MethodGet, location.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	client := remoteClient
+	if source != nil {
+		err = source.authorize(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		client = downloadClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading %s: %w", location.Host+location.Path, err)
+	}
+	switch response.StatusCode {
+	case http.StatusOK:
+		return response.Body, nil
+	case http.StatusNotFound:
+		closeReader(response.Body)
+		return nil, errObjectNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		closeReader(response.Body)
+		return nil, errObjectDenied
+	default:
+		closeReader(response.Body)
+		return nil, fmt.Errorf("error downloading %s: %s", location.Host+location.Path, response.Status)
+	}
+}
+
+// ObjectRequest is the body of POST /metadata/object, naming either the
+// source, bucket and key of an object or a presigned URL of it. The source
+// is one of s3, gs and azure and defaults to s3; for Azure the bucket is the
+// container and the key the blob.
+type ObjectRequest struct {
+	Source string `json:"source"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+}
+
+// handleObjectMetadata extracts the metadata of an object of one of the
+// sources. The object is streamed to exiftool -fast, so the download stops
+// as soon as exiftool found the metadata.
+func handleObjectMetadata(ctx context.Context, sources map[string]Source, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+
+		var request ObjectRequest
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxURLRequestSize)).Decode(&request)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+		var location *url.URL
+		var source Source
+		var name string
+		if request.URL != "" {
+			if request.Source != "" || request.Bucket != "" || request.Key != "" {
+				http.Error(w, "expected either a url or a bucket and key", http.StatusBadRequest)
+				return
+			}
+			location, err = parseDownloadURL(request.URL)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			name = location.Scheme + "://" + location.Host + location.Path
+		} else {
+			if request.Source == "" {
+				request.Source = "s3"
+			}
+			var ok bool
+			source, ok = sources[request.Source]
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid source %q", request.Source), http.StatusBadRequest)
+				return
+			}
+			location, err = source.objectURL(request.Bucket, request.Key)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			name = request.Source + "://" + request.Bucket + "/" + request.Key
+		}
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		body, err := openObject(commandCtx, source, location)
+		switch {
+		case errors.Is(err, errObjectNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, errObjectDenied):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			writeExtractionError(w, r, http.StatusBadGateway, err)
+			return
+		}
+		defer func() {
+			closeReader(body)
+		}()
+
+		metadata, err := extractStream(commandCtx, options, io.LimitReader(body, maxDownloadSize))
+		object, ok := singleMetadata(w, r, metadata, err, name)
+		if !ok {
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, object)
+	}
+}
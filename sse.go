@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// sseRetry is the reconnection delay in milliseconds sent to event stream
+// clients.
+const sseRetry = 3000
+
+// serveJobEvents sends the events of the job as Server-Sent Events until the
+// job finished. Every event carries its ID, so clients reconnecting with the
+// Last-Event-ID header only receive the events they missed, or 204 once
+// they received all events of a finished job.
+func serveJobEvents(jobs *jobQueue, w http.ResponseWriter, r *http.Request, id string) {
+	sent := 0
+	if value := r.Header.Get("Last-Event-ID"); value != "" {
+		var err error
+		sent, err = strconv.Atoi(value)
+		if err != nil || sent < 0 {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID header %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+	events, finished, _, ok := jobs.events(id, sent)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if finished && len(events) == 0 {
+		// Tells EventSource clients not to reconnect.
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Add("Content-Type", "text/event-stream")
+	w.Header().Add("Cache-Control", "no-cache")
+	_, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry)
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+		return
+	}
+	for {
+		events, finished, changed, ok := jobs.events(id, sent)
+		if !ok {
+			return
+		}
+		for _, event := range events {
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Printf("Error encoding event: %v\n", err)
+				return
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			if err != nil {
+				log.Printf("Error writing: %v\n", err)
+				return
+			}
+			sent = event.ID
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// stampField is the multipart form field of write requests holding a Stamp.
+const stampField = "stamp"
+
+// Stamp is a preset writing the creator and copyright of files. Its values
+// are templates, whose placeholders ${year}, the year a file was captured,
+// ${filename}, the name of a file, and ${artist}, the artist of the stamp,
+// are replaced for every file, e.g. "© ${year} ${artist}". Empty values are
+// left as they are.
+type Stamp struct {
+	Artist    string       `json:"artist"`
+	Copyright string       `json:"copyright"`
+	Contact   StampContact `json:"contact"`
+}
+
+// StampContact is the contact information of the creator written to the
+// IPTC Core CreatorContactInfo structure.
+type StampContact struct {
+	Address    string `json:"address"`
+	City       string `json:"city"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postalCode"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone"`
+	Email      string `json:"email"`
+	URL        string `json:"url"`
+}
+
+// stampPlaceholders are the exiftool expressions of the placeholders that
+// differ by file. Files without a capture date keep the values using
+// ${year}, as exiftool does not copy missing tags.
+var stampPlaceholders = map[string]string{
+	"year":     `${DateTimeOriginal;DateFmt("%Y")}`,
+	"filename": "${FileName}",
+}
+
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// stampAssignment returns the exiftool argument writing the template to the
+// tag. Templates with placeholders of the file are copied with -TAG<, which
+// evaluates the expressions and needs a literal $ to be doubled.
+func stampAssignment(tag string, template string, artist string) (string, error) {
+	var expanded strings.Builder
+	perFile := false
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(template, -1) {
+		expanded.WriteString(strings.Replace(template[last:match[0]], "$", "$$", -1))
+		name := template[match[2]:match[3]]
+		switch expression, ok := stampPlaceholders[name]; {
+		case ok:
+			expanded.WriteString(expression)
+			perFile = true
+		case name == "artist":
+			expanded.WriteString(strings.Replace(artist, "$", "$$", -1))
+		default:
+			return "", fmt.Errorf("invalid %s field: unknown placeholder ${%s}", stampField, name)
+		}
+		last = match[1]
+	}
+	expanded.WriteString(strings.Replace(template[last:], "$", "$$", -1))
+
+	if !perFile {
+		literal := strings.Replace(template, "${artist}", artist, -1)
+		return "-" + tag + "=" + literal, nil
+	}
+	return "-" + tag + "<" + expanded.String(), nil
+}
+
+// args returns the exiftool arguments writing the stamp to the EXIF and XMP
+// tags read by most applications.
+func (stamp Stamp) args() ([]string, error) {
+	if strings.Contains(stamp.Artist, "${") {
+		return nil, fmt.Errorf("invalid %s field: the artist cannot have placeholders", stampField)
+	}
+	templates := []struct {
+		tags     []string
+		template string
+	}{
+		{[]string{"EXIF:Artist", "XMP-dc:Creator"}, stamp.Artist},
+		{[]string{"EXIF:Copyright", "XMP-dc:Rights"}, stamp.Copyright},
+		{[]string{"XMP-iptcCore:CreatorAddress"}, stamp.Contact.Address},
+		{[]string{"XMP-iptcCore:CreatorCity"}, stamp.Contact.City},
+		{[]string{"XMP-iptcCore:CreatorRegion"}, stamp.Contact.Region},
+		{[]string{"XMP-iptcCore:CreatorPostalCode"}, stamp.Contact.PostalCode},
+		{[]string{"XMP-iptcCore:CreatorCountry"}, stamp.Contact.Country},
+		{[]string{"XMP-iptcCore:CreatorWorkTelephone"}, stamp.Contact.Phone},
+		{[]string{"XMP-iptcCore:CreatorWorkEmail"}, stamp.Contact.Email},
+		{[]string{"XMP-iptcCore:CreatorWorkURL"}, stamp.Contact.URL},
+	}
+	var args []string
+	for _, t := range templates {
+		if t.template == "" {
+			continue
+		}
+		for _, tag := range t.tags {
+			arg, err := stampAssignment(tag, t.template, stamp.Artist)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("invalid %s field: no values to write", stampField)
+	}
+	return args, nil
+}
+
+// decodeStamp decodes the stamp field of a write request into the exiftool
+// arguments writing it.
+func decodeStamp(part io.Reader) ([]string, error) {
+	decoder := json.NewDecoder(io.LimitReader(part, maxTagsLength))
+	decoder.DisallowUnknownFields()
+	var stamp Stamp
+	err := decoder.Decode(&stamp)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s field: %w", stampField, err)
+	}
+	return stamp.args()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+)
+
+// TagStats summarizes the tag database.
+type TagStats struct {
+	Total    int            `json:"total"`
+	Writable int            `json:"writable"`
+	ReadOnly int            `json:"readOnly"`
+	Groups   map[string]int `json:"groups"`
+	Types    map[string]int `json:"types"`
+}
+
+func newTagStats() *TagStats {
+	return &TagStats{
+		Groups: make(map[string]int),
+		Types:  make(map[string]int),
+	}
+}
+
+func (s *TagStats) add(tag Tag) {
+	s.Total++
+	if tag.Writable {
+		s.Writable++
+	} else {
+		s.ReadOnly++
+	}
+	s.Groups[tag.Group]++
+	s.Types[tag.Type]++
+}
+
+func handleTagStats(ctx context.Context, tags *tagCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		filter, err := newTagFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		db, err := tags.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		if notModified(w, r, db.ETag) {
+			return
+		}
+
+		stats := newTagStats()
+		for _, tag := range db.filter(filter) {
+			stats.add(tag)
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, stats)
+	}
+}
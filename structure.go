@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StructureTag is a tag of a directory in the structure of a file, with the
+// raw value and the position of the value in the file as far as exiftool
+// reports them.
+type StructureTag struct {
+	Index  *int    `json:"index,omitempty"`
+	Name   string  `json:"name"`
+	Value  *string `json:"value,omitempty"`
+	ID     string  `json:"id,omitempty"`
+	Format string  `json:"format,omitempty"`
+	Offset *int64  `json:"offset,omitempty"`
+	Size   *int64  `json:"size,omitempty"`
+}
+
+// StructureDirectory is a segment or directory in the structure of a file.
+type StructureDirectory struct {
+	Name        string                `json:"name"`
+	Entries     *int                  `json:"entries,omitempty"`
+	Offset      *int64                `json:"offset,omitempty"`
+	Size        *int64                `json:"size,omitempty"`
+	Tags        []*StructureTag       `json:"tags,omitempty"`
+	Directories []*StructureDirectory `json:"directories,omitempty"`
+}
+
+// Lines of the output of exiftool -v3 after the indentation of the nested
+// directories, e.g.
+//
+//	JPEG APP1 (428 bytes):
+//	  + [IFD0 directory with 10 entries]
+//	  | 0)  Make = Canon
+//	  |     - Tag 0x010f (6 bytes, string[6]):
+//	  |         0092: 43 61 6e 6f 6e 00                   [Canon.]
+var (
+	verboseSegmentPattern   = regexp.MustCompile(`^(.*?)(?: \((\d+) bytes\))?:?$`)
+	verboseDirectoryPattern = regexp.MustCompile(`^\+ \[(.+?)(?: directory)?(?: with (\d+) entries|, (\d+) bytes)?\]$`)
+	verboseTagPattern       = regexp.MustCompile(`^(?:(\d+)\)\s+)?([A-Za-z0-9_-]+(?::[A-Za-z0-9_-]+)*)(?: \(SubDirectory\) -->| = (.*))$`)
+	verboseDetailPattern    = regexp.MustCompile(`^- Tag (\S+) \((\d+) bytes(?:, ([^)]*))?\):?$`)
+	verboseDumpPattern      = regexp.MustCompile(`^([0-9a-f]{4,}):`)
+)
+
+func parseInt(value string) *int {
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+func parseSize(value string, base int) *int64 {
+	if value == "" {
+		return nil
+	}
+	n, err := strconv.ParseInt(value, base, 64)
+	if err != nil {
+		return nil
+	}
+	return &n
+}
+
+// parseVerboseStructure parses the output of exiftool -v3 into the tree of
+// the segments and directories of the file. Lines it does not recognize,
+// like warnings, are skipped.
+func parseVerboseStructure(reader io.Reader, name string) (*StructureDirectory, error) {
+	root := &StructureDirectory{Name: name}
+	// directories[depth+1] is the directory of the lines indented by depth.
+	directories := []*StructureDirectory{root, root}
+	var tag *StructureTag
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, " ") {
+			match := verboseSegmentPattern.FindStringSubmatch(line)
+			segment := &StructureDirectory{Name: match[1], Size: parseSize(match[2], 10)}
+			root.Directories = append(root.Directories, segment)
+			directories = []*StructureDirectory{root, segment}
+			tag = nil
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "  ")
+		depth := 0
+		for strings.HasPrefix(line, "| ") {
+			line = line[2:]
+			depth++
+		}
+		line = strings.TrimSpace(line)
+		if depth+1 >= len(directories) {
+			depth = len(directories) - 2
+		}
+		directory := directories[depth+1]
+
+		if match := verboseDirectoryPattern.FindStringSubmatch(line); match != nil {
+			child := &StructureDirectory{Name: match[1], Entries: parseInt(match[2]), Size: parseSize(match[3], 10)}
+			directory.Directories = append(directory.Directories, child)
+			directories = append(directories[:depth+2], child)
+			tag = nil
+		} else if match := verboseTagPattern.FindStringSubmatch(line); match != nil {
+			tag = &StructureTag{Index: parseInt(match[1]), Name: match[2]}
+			if strings.Contains(line, " = ") {
+				tag.Value = &match[3]
+			}
+			directory.Tags = append(directory.Tags, tag)
+		} else if match := verboseDetailPattern.FindStringSubmatch(line); match != nil && tag != nil {
+			tag.ID = match[1]
+			tag.Size = parseSize(match[2], 10)
+			tag.Format = match[3]
+		} else if match := verboseDumpPattern.FindStringSubmatch(line); match != nil {
+			switch {
+			case tag != nil && tag.Offset == nil:
+				tag.Offset = parseSize(match[1], 16)
+			case tag == nil && directory.Offset == nil:
+				directory.Offset = parseSize(match[1], 16)
+			}
+		}
+	}
+	return root, scanner.Err()
+}
+
+// handleStructure reports the structure of a file given like for
+// /metadata/htmldump as parsed from exiftool -v3, with the segments and
+// directories of the file, their tags, and the offsets and sizes of both, for
+// debugging malformed files.
+func handleStructure(ctx context.Context, local *localFiles) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		file, remove, ok := requestFile(local, w, r)
+		if !ok {
+			return
+		}
+		defer remove()
+
+		commandCtx, cancel := commandContext(ctx, r)
+		defer cancel()
+		cmd, reader, err := startExiftool(commandCtx, nil, "-v3", file.path)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		structure, err := parseVerboseStructure(reader, file.name)
+		waitExiftool(cmd, reader)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusInternalServerError, err)
+			return
+		}
+		if len(structure.Tags) == 0 && len(structure.Directories) == 0 {
+			http.Error(w, "no structure extracted", http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, structure)
+	}
+}
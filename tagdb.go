@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TagDatabase is the parsed output of exiftool -listx.
+type TagDatabase struct {
+	Tables []Table
+	Tags   []Tag
+	ETag   string
+	raw    []byte
+	index  *searchIndex
+	// names are the lower cased names of the tags.
+	names map[string]bool
+	// store is the SQLite file the database is persisted to, if any.
+	store *sql.DB
+}
+
+// hasTag reports whether the database has a tag of the name, ignoring case
+// like exiftool.
+func (db *TagDatabase) hasTag(name string) bool {
+	return db.names[strings.ToLower(name)]
+}
+
+// tagSnapshot is the exiftool output a tag database is parsed from, as
+// persisted to disk.
+type tagSnapshot struct {
+	Version []byte
+	Raw     []byte
+}
+
+// loadTagDatabase runs exiftool -listx, with the config file if not empty,
+// and parses its output.
+func loadTagDatabase(ctx context.Context, config string) (*TagDatabase, tagSnapshot, error) {
+	var snapshot tagSnapshot
+	version, err := runExiftool(ctx, nil, "-ver")
+	if err != nil {
+		return nil, snapshot, fmt.Errorf("error reading exiftool version: %w", err)
+	}
+	args := []string{"-listx"}
+	if config != "" {
+		args = append([]string{"-config", config}, args...)
+	}
+	raw, err := runExiftool(ctx, nil, args...)
+	if err != nil {
+		return nil, snapshot, fmt.Errorf("error listing tags: %w", err)
+	}
+	snapshot = tagSnapshot{Version: version, Raw: raw}
+	db, err := parseTagDatabase(snapshot)
+	return db, snapshot, err
+}
+
+// parseTagDatabase parses the snapshot. The ETag is a hash of the exiftool
+// version and the raw output.
+func parseTagDatabase(snapshot tagSnapshot) (*TagDatabase, error) {
+	db := &TagDatabase{raw: snapshot.Raw, names: make(map[string]bool)}
+	seen := make(map[string]bool)
+	err := decodeTags(bytes.NewReader(snapshot.Raw), func(table Table, tag Tag) error {
+		if !seen[table.Name] {
+			seen[table.Name] = true
+			db.Tables = append(db.Tables, table)
+		}
+		db.Tags = append(db.Tags, tag)
+		db.names[strings.ToLower(tag.Name())] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error decoding tags: %w", err)
+	}
+
+	db.index = newSearchIndex(db.Tags)
+
+	hash := sha256.New()
+	_, _ = hash.Write(snapshot.Version)
+	_, _ = hash.Write(snapshot.Raw)
+	db.ETag = fmt.Sprintf("W/\"%s\"", hex.EncodeToString(hash.Sum(nil)))
+	return db, nil
+}
+
+// tagCache keeps the tag database in memory, loading it on first use. If a
+// snapshot path is set, the database is read from the SQLite file at it when
+// present and written to it whenever it is generated, so it survives restarts
+// without exiftool and its tags are filtered with SQL.
+// If a config file is set, the database includes the tags it defines.
+type tagCache struct {
+	mu           sync.RWMutex
+	db           *TagDatabase
+	snapshotPath string
+	config       string
+}
+
+// load reads the persisted snapshot if there is one of the installed exiftool
+// version and generates the database otherwise. If the version cannot be
+// determined, e.g. because exiftool is not installed, the snapshot is used.
+func (c *tagCache) load(ctx context.Context) (*TagDatabase, error) {
+	if c.snapshotPath != "" {
+		snapshot, store, err := readTagSnapshot(c.snapshotPath)
+		if err == nil {
+			version, err := runExiftool(ctx, nil, "-ver")
+			if err != nil {
+				log.Printf("Error reading exiftool version, using tag snapshot %s: %v\n", c.snapshotPath, err)
+			} else if !bytes.Equal(version, snapshot.Version) {
+				_ = store.Close()
+				log.Printf("Regenerating tag snapshot %s of exiftool %s for exiftool %s\n", c.snapshotPath,
+					bytes.TrimSpace(snapshot.Version), bytes.TrimSpace(version))
+				return c.generate(ctx)
+			}
+			db, err := parseTagDatabase(snapshot)
+			if err != nil {
+				_ = store.Close()
+				return nil, err
+			}
+			db.store = store
+			return db, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("%v\n", err)
+		}
+	}
+	return c.generate(ctx)
+}
+
+// generate runs exiftool and persists the result if a snapshot path is set.
+func (c *tagCache) generate(ctx context.Context) (*TagDatabase, error) {
+	db, snapshot, err := loadTagDatabase(ctx, c.config)
+	if err != nil {
+		return nil, err
+	}
+	if c.snapshotPath != "" {
+		db.store, err = writeTagSnapshot(c.snapshotPath, snapshot, db)
+		if err != nil {
+			log.Printf("%v\n", err)
+		}
+	}
+	return db, nil
+}
+
+// get returns the cached tag database, loading it if necessary.
+func (c *tagCache) get(ctx context.Context) (*TagDatabase, error) {
+	c.mu.RLock()
+	db := c.db
+	c.mu.RUnlock()
+	if db != nil {
+		return db, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.db != nil {
+		return c.db, nil
+	}
+	db, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.db = db
+	return db, nil
+}
+
+// refresh regenerates the tag database and replaces the cached one.
+func (c *tagCache) refresh(ctx context.Context) (*TagDatabase, error) {
+	db, err := c.generate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	previous := c.db
+	c.db = db
+	c.mu.Unlock()
+	if previous != nil {
+		previous.close()
+	}
+	return db, nil
+}
+
+// handleRefresh reloads the tag database for requests authorized with the
+// admin token as a bearer token, as reloading runs exiftool over its whole
+// tag table.
+func handleRefresh(ctx context.Context, tags *tagCache, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		db, err := tags.refresh(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, struct {
+			Tags int    `json:"tags"`
+			ETag string `json:"etag"`
+		}{len(db.Tags), db.ETag})
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+type Description struct {
+	Language string `xml:"lang,attr"`
+	Content  string `xml:",chardata"`
+}
+
+// TagValue is one of the allowed values of a tag with its localized labels.
+type TagValue struct {
+	Key      string            `json:"key" xml:"id,attr"`
+	Labels   []Description     `xml:"val" json:"-"`
+	LabelMap map[string]string `json:"labels"`
+}
+
+// GroupFamilies holds the ExifTool family 0, 1 and 2 group names.
+type GroupFamilies struct {
+	Family0 string `json:"g0" xml:"g0,attr"`
+	Family1 string `json:"g1" xml:"g1,attr"`
+	Family2 string `json:"g2" xml:"g2,attr"`
+}
+
+func newGroupFamilies(atts []xml.Attr) GroupFamilies {
+	var families GroupFamilies
+	for _, a := range atts {
+		switch a.Name.Local {
+		case "g0":
+			families.Family0 = a.Value
+		case "g1":
+			families.Family1 = a.Value
+		case "g2":
+			families.Family2 = a.Value
+		}
+	}
+	return families
+}
+
+// inherit fills the families that are not overridden from the parent families.
+func (f *GroupFamilies) inherit(parent GroupFamilies) {
+	if f.Family0 == "" {
+		f.Family0 = parent.Family0
+	}
+	if f.Family1 == "" {
+		f.Family1 = parent.Family1
+	}
+	if f.Family2 == "" {
+		f.Family2 = parent.Family2
+	}
+}
+
+// TagFlags are the flags ExifTool attaches to a tag definition.
+type TagFlags struct {
+	Avoid     bool     `json:"avoid"`
+	Binary    bool     `json:"binary"`
+	Protected bool     `json:"protected"`
+	Permanent bool     `json:"permanent"`
+	Unsafe    bool     `json:"unsafe"`
+	Mandatory bool     `json:"mandatory"`
+	List      bool     `json:"list"`
+	Names     []string `json:"-"`
+}
+
+// UnmarshalXMLAttr parses the comma separated flags attribute of -listx.
+func (f *TagFlags) UnmarshalXMLAttr(attr xml.Attr) error {
+	for _, name := range strings.Split(attr.Value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		f.Names = append(f.Names, name)
+		switch name {
+		case "Avoid":
+			f.Avoid = true
+		case "Binary":
+			f.Binary = true
+		case "Protected":
+			f.Protected = true
+		case "Permanent":
+			f.Permanent = true
+		case "Unsafe":
+			f.Unsafe = true
+		case "Mandatory":
+			f.Mandatory = true
+		case "List":
+			f.List = true
+		}
+	}
+	return nil
+}
+
+// Has reports whether the flag with the given name is set, ignoring case.
+func (f TagFlags) Has(name string) bool {
+	for _, flag := range f.Names {
+		if strings.EqualFold(flag, name) {
+			return true
+		}
+	}
+	return false
+}
+
+type Tag struct {
+	ID             string `json:"id" xml:"id,attr"`
+	Count          string `json:"count,omitempty" xml:"count,attr"`
+	Writable       bool   `json:"writable" xml:"writable,attr"`
+	Path           string `json:"path" xml:"name,attr"`
+	Group          string `json:"group"`
+	GroupFamilies  `json:"families"`
+	Descriptions   []Description     `xml:"desc" json:"-"`
+	DescriptionMap map[string]string `json:"descriptions"`
+	Type           string            `json:"type" xml:"type,attr"`
+	Flags          TagFlags          `json:"flags" xml:"flags,attr"`
+	Values         []TagValue        `json:"values,omitempty" xml:"values>key"`
+}
+
+// Name returns the tag name without the group prefix of its path.
+func (t Tag) Name() string {
+	return strings.TrimPrefix(t.Path, t.Group+":")
+}
+
+// IsComposite reports whether the tag is a Composite tag derived from other tags.
+func (t Tag) IsComposite() bool {
+	return t.Family0 == "Composite"
+}
+
+// Description returns the description of the tag in the given language.
+func (t Tag) Description(language string) string {
+	for _, description := range t.Descriptions {
+		if description.Language == language {
+			return description.Content
+		}
+	}
+	return ""
+}
+
+// CreateDescriptionMap fills the description and value label maps from the
+// parsed descriptions, keeping only the given languages unless the set is empty.
+// The maps and values are newly allocated, so copies of a cached tag can be
+// localized independently.
+func (t *Tag) CreateDescriptionMap(languages map[string]bool) {
+	t.DescriptionMap = make(map[string]string)
+	fillDescriptionMap(t.DescriptionMap, t.Descriptions, languages)
+	values := make([]TagValue, len(t.Values))
+	for i, value := range t.Values {
+		value.LabelMap = make(map[string]string)
+		fillDescriptionMap(value.LabelMap, value.Labels, languages)
+		values[i] = value
+	}
+	if len(values) > 0 {
+		t.Values = values
+	}
+}
+
+func fillDescriptionMap(target map[string]string, descriptions []Description, languages map[string]bool) {
+	for _, description := range descriptions {
+		if len(languages) > 0 && !languages[description.Language] {
+			continue
+		}
+		target[description.Language] = description.Content
+	}
+}
+
+// contains reports whether the lower cased search term occurs in the tag path
+// or any of its descriptions, ignoring case.
+func (t Tag) contains(search string) bool {
+	if strings.Contains(strings.ToLower(t.Path), search) {
+		return true
+	}
+	for _, description := range t.Descriptions {
+		if strings.Contains(strings.ToLower(description.Content), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// getXMLAttribute returns the value of the first attribute with the given name.
+func getXMLAttribute(atts []xml.Attr, name string) *string {
+	for _, a := range atts {
+		if a.Name.Local == name {
+			return &a.Value
+		}
+	}
+	return nil
+}
+
+// Table is a tag table of the ExifTool tag database.
+type Table struct {
+	Name          string `json:"name"`
+	GroupFamilies `json:"families"`
+}
+
+func newTable(atts []xml.Attr) Table {
+	table := Table{GroupFamilies: newGroupFamilies(atts)}
+	if name := getXMLAttribute(atts, "name"); name != nil {
+		table.Name = *name
+	}
+	return table
+}
+
+// decodeTags reads the output of exiftool -listx and calls visit for every tag
+// in the order they appear, together with the table the tag belongs to.
+func decodeTags(reader io.Reader, visit func(table Table, tag Tag) error) error {
+	decoder := xml.NewDecoder(reader)
+	var table Table
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		n, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch n.Name.Local {
+		case "table":
+			table = newTable(n.Attr)
+		case "tag":
+			var tag Tag
+			err = decoder.DecodeElement(&tag, &n)
+			if err != nil {
+				log.Printf("Error decoding: %v\n", err)
+				continue
+			}
+			tag.GroupFamilies.inherit(table.GroupFamilies)
+			if table.Name != "" {
+				tag.Group = table.Name
+				tag.Path = fmt.Sprintf("%s:%s", tag.Group, tag.Path)
+			}
+			err = visit(table, tag)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
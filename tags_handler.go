@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// flushInterval is the number of tags after which the /tags response is
+// flushed to the client unless every tag is flushed with stream=true.
+const flushInterval = 100
+
+func handleTags(ctx context.Context, tags *tagCache, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		var total int
+
+		filter, err := newTagFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cache := tags
+		if value := getQueryParameter(r.URL.Query(), "config"); value != nil {
+			cache, err = configs.tagCache(*value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid config parameter %q", *value), http.StatusBadRequest)
+				return
+			}
+		}
+		if group := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tags"), "/"); group != "" {
+			if i := strings.Index(group, "/"); i >= 0 {
+				serveTag(ctx, cache, w, r, group[:i], group[i+1:])
+				return
+			}
+			filter.group = &group
+		}
+		page, err := newPagination(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		order, err := newTagOrder(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		languages := getLanguages(r.URL.Query())
+
+		var stream bool
+		if value := getQueryParameter(r.URL.Query(), "stream"); value != nil {
+			stream, err = strconv.ParseBool(*value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid stream parameter %q", *value), http.StatusBadRequest)
+				return
+			}
+		}
+		flusher, _ := w.(http.Flusher)
+
+		w.Header().Add("Vary", "Accept")
+		format, ok := negotiateFormat(r)
+		if !ok {
+			http.Error(w, "none of the accepted media types is supported", http.StatusNotAcceptable)
+			return
+		}
+		if format == "xml" {
+			serveRawTags(ctx, cache, w, r)
+			return
+		}
+		encoder, err := newTagEncoder(format, w, getQueryList(r.URL.Query(), "fields"), isPretty(r.URL.Query()))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		db, err := cache.get(ctx)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		if notModified(w, r, db.ETag) {
+			return
+		}
+
+		matched := db.filter(filter)
+		if order != nil {
+			order.sort(matched)
+		}
+
+		w.Header().Add("Content-Type", encoder.contentType())
+		err = encoder.begin()
+		if err != nil {
+			log.Printf("Error writing: %v\n", err)
+			return
+		}
+		for _, tag := range matched {
+			total++
+			if !page.includes(total - 1) {
+				continue
+			}
+			tag.CreateDescriptionMap(languages)
+
+			err = encoder.encode(tag)
+			if err != nil {
+				log.Printf("Error writing: %v\n", err)
+				return
+			}
+			if flusher != nil && (stream || total%flushInterval == 0) {
+				err = encoder.flush()
+				if err != nil {
+					log.Printf("Error writing: %v\n", err)
+					return
+				}
+				flusher.Flush()
+			}
+		}
+
+		err = encoder.end(total, page)
+		if err != nil {
+			log.Printf("Error writing: %v\n", err)
+		}
+	}
+}
+
+// serveTag writes the definition of the tag with the given group and name,
+// responding with 404 if the tag database does not contain it.
+func serveTag(ctx context.Context, tags *tagCache, w http.ResponseWriter, r *http.Request, group string, name string) {
+	languages := getLanguages(r.URL.Query())
+
+	db, err := tags.get(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+	if notModified(w, r, db.ETag) {
+		return
+	}
+
+	var found *Tag
+	for _, tag := range db.Tags {
+		if (tag.Group == group || tag.Family1 == group) && tag.Name() == name {
+			found = &tag
+			break
+		}
+	}
+	if found == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	found.CreateDescriptionMap(languages)
+	w.Header().Add("Content-Type", "application/json")
+	writeJSON(w, r, found)
+}
+
+// serveRawTags writes the unmodified output of exiftool -listx.
+func serveRawTags(ctx context.Context, tags *tagCache, w http.ResponseWriter, r *http.Request) {
+	db, err := tags.get(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+	if notModified(w, r, db.ETag) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/xml; charset=utf-8")
+	_, err = w.Write(db.raw)
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+	}
+}
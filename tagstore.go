@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Registers the pure Go SQLite driver, so no C compiler is needed.
+	_ "modernc.org/sqlite"
+)
+
+// tagStoreSchema creates the tables of the SQLite file the tag database is
+// persisted to: the exiftool output it is parsed from and its tags, numbered
+// in the order of exiftool, with the fields the filters of /tags select by.
+const tagStoreSchema = `
+CREATE TABLE snapshot (version BLOB NOT NULL, raw BLOB NOT NULL);
+CREATE TABLE tags (
+	position INTEGER PRIMARY KEY,
+	path TEXT NOT NULL,
+	name TEXT NOT NULL,
+	"group" TEXT NOT NULL,
+	family0 TEXT NOT NULL,
+	family1 TEXT NOT NULL,
+	family2 TEXT NOT NULL,
+	type TEXT NOT NULL,
+	writable INTEGER NOT NULL
+);
+CREATE INDEX tags_group ON tags ("group");
+CREATE INDEX tags_family1 ON tags (family1);
+CREATE INDEX tags_type ON tags (type);
+`
+
+// openTagStore opens the SQLite file. Its single connection is kept open, so
+// it keeps reading the file it was opened on after a refresh replaced it.
+func openTagStore(path string) (*sql.DB, error) {
+	store, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	store.SetMaxOpenConns(1)
+	store.SetMaxIdleConns(1)
+	err = store.Ping()
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// readTagSnapshot reads the snapshot from the SQLite file written by
+// writeTagSnapshot and returns the opened file to filter the tags with.
+func readTagSnapshot(path string) (tagSnapshot, *sql.DB, error) {
+	var snapshot tagSnapshot
+	// SQLite would create a missing file.
+	_, err := os.Stat(path)
+	if err != nil {
+		return snapshot, nil, err
+	}
+	store, err := openTagStore(path)
+	if err == nil {
+		err = store.QueryRow("SELECT version, raw FROM snapshot").Scan(&snapshot.Version, &snapshot.Raw)
+		if err != nil {
+			_ = store.Close()
+		}
+	}
+	if err != nil {
+		return snapshot, nil, fmt.Errorf("error reading tag snapshot %s: %w", path, err)
+	}
+	return snapshot, store, nil
+}
+
+// writeTagSnapshot persists the snapshot and the tags of the database parsed
+// from it to a SQLite file, replacing the file atomically, and returns the
+// written file opened to filter the tags with.
+func writeTagSnapshot(path string, snapshot tagSnapshot, db *TagDatabase) (*sql.DB, error) {
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating tag snapshot: %w", err)
+	}
+	err = file.Close()
+	if err == nil {
+		err = writeTagStore(file.Name(), snapshot, db)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return nil, fmt.Errorf("error writing tag snapshot %s: %w", path, err)
+	}
+	store, err := openTagStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening tag snapshot %s: %w", path, err)
+	}
+	return store, nil
+}
+
+// writeTagStore creates the tables in the empty SQLite file in a single
+// transaction.
+func writeTagStore(path string, snapshot tagSnapshot, db *TagDatabase) error {
+	store, err := sql.Open("sqlite", path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	tx, err := store.Begin()
+	if err != nil {
+		return err
+	}
+	err = insertTags(tx, snapshot, db)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertTags(tx *sql.Tx, snapshot tagSnapshot, db *TagDatabase) error {
+	_, err := tx.Exec(tagStoreSchema)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO snapshot (version, raw) VALUES (?, ?)", snapshot.Version, snapshot.Raw)
+	if err != nil {
+		return err
+	}
+	insert, err := tx.Prepare(`INSERT INTO tags (position, path, name, "group", family0, family1, family2, type, writable) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = insert.Close()
+	}()
+	for position, tag := range db.Tags {
+		_, err = insert.Exec(position, tag.Path, tag.Name(), tag.Group, tag.Family0, tag.Family1, tag.Family2, tag.Type, tag.Writable)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sqlConditions returns the conditions of the filter on the indexed fields of
+// the stored tags joined with AND, empty if there are none, and their
+// arguments.
+func (f tagFilter) sqlConditions() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if f.group != nil {
+		conditions = append(conditions, `("group" = ? OR family1 = ?)`)
+		args = append(args, *f.group, *f.group)
+	}
+	if f.writable != nil {
+		conditions = append(conditions, "writable = ?")
+		args = append(args, *f.writable)
+	}
+	if f.valueType != nil {
+		conditions = append(conditions, "type = ?")
+		args = append(args, *f.valueType)
+	}
+	if f.composite != nil {
+		conditions = append(conditions, "(family0 = 'Composite') = ?")
+		args = append(args, *f.composite)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// filter returns the tags matching the filter in the order of exiftool. If
+// the database is stored in a SQLite file, the tags are narrowed down with
+// SQL first, falling back to checking every tag if that fails, e.g. because
+// a refresh closed the file.
+func (db *TagDatabase) filter(f tagFilter) []Tag {
+	tags := db.Tags
+	if conditions, args := f.sqlConditions(); db.store != nil && conditions != "" {
+		selected, err := db.selectTags(conditions, args)
+		if err != nil {
+			log.Printf("Error filtering tags: %v\n", err)
+		} else {
+			tags = selected
+		}
+	}
+	matched := make([]Tag, 0)
+	for _, tag := range tags {
+		if f.matches(tag) {
+			matched = append(matched, tag)
+		}
+	}
+	return matched
+}
+
+// selectTags returns the stored tags matching the SQL conditions.
+func (db *TagDatabase) selectTags(conditions string, args []interface{}) ([]Tag, error) {
+	rows, err := db.store.Query("SELECT position FROM tags WHERE "+conditions+" ORDER BY position", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	var tags []Tag
+	for rows.Next() {
+		var position int
+		err = rows.Scan(&position)
+		if err != nil {
+			return nil, err
+		}
+		if position < 0 || position >= len(db.Tags) {
+			return nil, fmt.Errorf("stored tag %d out of range", position)
+		}
+		tags = append(tags, db.Tags[position])
+	}
+	return tags, rows.Err()
+}
+
+// close closes the SQLite file the database is stored in, if any.
+func (db *TagDatabase) close() {
+	if db.store == nil {
+		return
+	}
+	err := db.store.Close()
+	if err != nil {
+		log.Printf("Error closing tag snapshot: %v\n", err)
+	}
+}
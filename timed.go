@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// sampleTags are the tags giving the time of a sample.
+var sampleTags = []string{"SampleTime", "SampleDuration"}
+
+// TimedSample is the metadata of a sample of a video, like the GPS position
+// of a drone or the acceleration of an action cam at the sample time.
+type TimedSample struct {
+	Document string      `json:"document"`
+	Time     interface{} `json:"time,omitempty"`
+	Duration interface{} `json:"duration,omitempty"`
+	Metadata Metadata    `json:"metadata"`
+}
+
+// timedSamples collects the embedded documents of the metadata extracted
+// with -G3 as samples in document order.
+func timedSamples(metadata Metadata) []TimedSample {
+	samples := make([]TimedSample, 0)
+	for group, tags := range documentTags(metadata) {
+		if !strings.HasPrefix(group, "Doc") || documentNumber(group) == nil {
+			continue
+		}
+		sample := TimedSample{Document: group, Time: tags["SampleTime"], Duration: tags["SampleDuration"], Metadata: tags}
+		for _, tag := range sampleTags {
+			delete(tags, tag)
+		}
+		samples = append(samples, sample)
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return lessDocument(documentNumber(samples[i].Document), documentNumber(samples[j].Document))
+	})
+	return samples
+}
+
+// handleTimedMetadata extracts the timed metadata of the samples of a video
+// sent like for POST /metadata with -ee3, e.g. from GoPro, drone or dashcam
+// footage, and returns the samples with their times.
+func handleTimedMetadata(ctx context.Context, configs *exiftoolConfigs) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newExtractionOptions(ctx, r.URL.Query(), configs)
+		if err != nil {
+			writeOptionsError(w, err)
+			return
+		}
+		options.groups = "-G3"
+		options.embedded = 3
+		if len(options.tags) > 0 {
+			options.tags = append(options.tags, sampleTags...)
+		}
+
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+		samples := timedSamples(metadata)
+		if len(samples) == 0 {
+			http.Error(w, "no timed metadata extracted", http.StatusUnprocessableEntity)
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, samples)
+	}
+}
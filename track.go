@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// GPX is a GPX 1.1 document with a single track, see
+// https://www.topografix.com/GPX/1/1/.
+type GPX struct {
+	XMLName xml.Name `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version string   `xml:"version,attr"`
+	Creator string   `xml:"creator,attr"`
+	Track   GPXTrack `xml:"trk"`
+}
+
+// GPXTrack is a track of a single segment.
+type GPXTrack struct {
+	Name    string     `xml:"name"`
+	Segment GPXSegment `xml:"trkseg"`
+}
+
+// GPXSegment is a sequence of track points.
+type GPXSegment struct {
+	Points []GPXPoint `xml:"trkpt"`
+}
+
+// GPXPoint is a position of a track.
+type GPXPoint struct {
+	Latitude  float64  `xml:"lat,attr"`
+	Longitude float64  `xml:"lon,attr"`
+	Elevation *float64 `xml:"ele,omitempty"`
+	Time      string   `xml:"time,omitempty"`
+}
+
+// gpxTime converts a date and time as written by exiftool, e.g.
+// 2024:01:01 10:00:00Z, to the ISO 8601 format of GPX.
+func gpxTime(value string) string {
+	if len(value) < 19 || value[4] != ':' || value[7] != ':' || value[10] != ' ' {
+		return ""
+	}
+	return value[:4] + "-" + value[5:7] + "-" + value[8:10] + "T" + value[11:]
+}
+
+// newGPX returns a GPX document of the track of the file.
+func newGPX(source string, points []gpsPoint) GPX {
+	gpx := GPX{Version: "1.1", Creator: "exiftool2json", Track: GPXTrack{Name: source}}
+	for _, point := range points {
+		trackPoint := GPXPoint{Latitude: point.position[1], Longitude: point.position[0], Time: gpxTime(point.time)}
+		if len(point.position) > 2 {
+			trackPoint.Elevation = &point.position[2]
+		}
+		gpx.Track.Segment.Points = append(gpx.Track.Segment.Points, trackPoint)
+	}
+	return gpx
+}
+
+// handleTrackMetadata extracts the GPS track embedded in a video sent like
+// for POST /metadata, e.g. of drone or action cam footage, and returns it as
+// GPX or, with format=geojson, as a GeoJSON LineString feature.
+func handleTrackMetadata(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		format := "gpx"
+		if value := getQueryParameter(r.URL.Query(), "format"); value != nil {
+			format = *value
+		}
+		if format != "gpx" && format != "geojson" {
+			http.Error(w, fmt.Sprintf("invalid format parameter %q", format), http.StatusBadRequest)
+			return
+		}
+
+		options := extractionOptions{
+			tags:       gpsTags,
+			groups:     "-G3",
+			numeric:    true,
+			embedded:   3,
+			duplicates: true,
+		}
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+		points := gpsPoints(metadata)
+		if len(points) < 2 {
+			http.Error(w, "no GPS track extracted", http.StatusUnprocessableEntity)
+			return
+		}
+		source := metadata["SourceFile"].(string)
+
+		if format == "geojson" {
+			w.Header().Add("Content-Type", "application/geo+json")
+			writeJSON(w, r, newGeoJSONFeature(source, points))
+			return
+		}
+
+		w.Header().Add("Content-Type", "application/gpx+xml")
+		_, err := w.Write([]byte(xml.Header))
+		if err == nil {
+			encoder := xml.NewEncoder(w)
+			if isPretty(r.URL.Query()) {
+				encoder.Indent("", "  ")
+			}
+			err = encoder.Encode(newGPX(source, points))
+		}
+		if err != nil {
+			log.Printf("Error writing: %v\n", err)
+		}
+	}
+}
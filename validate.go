@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// ValidationResult is the outcome of exiftool -validate for a file.
+type ValidationResult struct {
+	SourceFile string   `json:"sourceFile"`
+	Valid      bool     `json:"valid"`
+	Summary    string   `json:"summary"`
+	Warnings   []string `json:"warnings"`
+	Errors     []string `json:"errors"`
+}
+
+// metadataStrings returns the values of a tag, which is extracted as an array
+// if it occurs more than once.
+func metadataStrings(value interface{}) []string {
+	values := make([]string, 0)
+	switch v := value.(type) {
+	case nil:
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	default:
+		values = append(values, fmt.Sprint(v))
+	}
+	return values
+}
+
+// handleValidate validates a file sent like for POST /metadata, returning all
+// the warnings and errors exiftool found.
+func handleValidate(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options := extractionOptions{
+			tags:       []string{"Validate", "Warning", "Error"},
+			duplicates: true,
+		}
+		metadata, ok := extractRequestFile(ctx, w, r, options)
+		if !ok {
+			return
+		}
+
+		result := ValidationResult{
+			SourceFile: fmt.Sprint(metadata["SourceFile"]),
+			Warnings:   metadataStrings(metadata["Warning"]),
+			Errors:     metadataStrings(metadata["Error"]),
+		}
+		if summary := metadataStrings(metadata["Validate"]); len(summary) > 0 {
+			result.Summary = summary[0]
+		}
+		result.Valid = len(result.Warnings) == 0 && len(result.Errors) == 0
+
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, result)
+	}
+}
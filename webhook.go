@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Delivery of webhooks, which is retried with exponential backoff.
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookTimeout  = 30 * time.Second
+)
+
+// webhookClient posts to public addresses only, like remoteClient.
+var webhookClient = newRemoteClient(webhookTimeout)
+
+// JobNotification is the body of the webhook called when a job finished.
+type JobNotification struct {
+	Job     Job    `json:"job"`
+	Results string `json:"results,omitempty"`
+}
+
+// callback returns the webhook of the callback parameter of a job request,
+// nil if there is none. Callbacks need a webhook secret, so that receivers
+// can tell the notifications of the server from forged ones.
+func (jobs *jobQueue) callback(query url.Values) (*url.URL, error) {
+	value := getQueryParameter(query, "callback")
+	if value == nil {
+		return nil, nil
+	}
+	if len(jobs.webhookSecret) == 0 {
+		return nil, errors.New("callback parameter requires a webhook secret to be configured")
+	}
+	callback, err := parseDownloadURL(*value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback parameter %q: %w", *value, err)
+	}
+	return callback, nil
+}
+
+// webhookSignature returns the signature of a webhook request, the hex
+// encoded HMAC-SHA256 of the timestamp and the body joined by a dot.
+func webhookSignature(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify posts the notification to the webhook until it responds with a 2xx
+// status. If a secret is configured, the request carries its signature in
+// X-Signature-256 and the signed Unix time in X-Webhook-Timestamp, so the
+// receiver can verify it and reject replays.
+func notify(ctx context.Context, callback *url.URL, secret []byte, notification JobNotification) {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		log.Printf("Error encoding webhook: %v\n", err)
+		return
+	}
+	backoff := webhookBackoff
+	for attempt := 1; ; attempt++ {
+		err = postWebhook(ctx, callback, secret, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			log.Printf("Giving up calling webhook of job %s: %v\n", notification.Job.ID, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+			backoff *= 2
+		}
+	}
+}
+
+func postWebhook(ctx context.Context, callback *url.URL, secret []byte, body []byte) error {
+	request, err := http.NewRequest(http.MethodPost, callback.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if len(secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		request.Header.Set("X-Webhook-Timestamp", timestamp)
+		request.Header.Set("X-Signature-256", webhookSignature(secret, timestamp, body))
+	}
+	response, err := webhookClient.Do(request.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	closeReader(response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webSocketGUID is appended to the key of the client to accept the
+// connection, see RFC 6455 section 1.3.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes, see RFC 6455 section 5.2.
+const (
+	webSocketText  = 0x1
+	webSocketClose = 0x8
+	webSocketPing  = 0x9
+	webSocketPong  = 0xa
+)
+
+// closeTimeout is how long the client has to acknowledge closing a WebSocket.
+const closeTimeout = 5 * time.Second
+
+// maxWebSocketFrame limits the size of the frames read from clients, which
+// only send control frames.
+const maxWebSocketFrame = 64 << 10
+
+// webSocket is a server side WebSocket connection.
+type webSocket struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	mu     sync.Mutex
+	writer *bufio.Writer
+}
+
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range strings.Split(header.Get(name), ",") {
+		if strings.EqualFold(strings.TrimSpace(value), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket performs the opening handshake of a WebSocket, responding
+// with 400 if the request is not a valid WebSocket request.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*webSocket, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != http.MethodGet || !headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") || r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "expected a WebSocket upgrade request", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket request")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, errors.New("connection does not support hijacking")
+	}
+	conn, buffered, err := hijacker.Hijack()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, fmt.Errorf("error hijacking connection: %w", err)
+	}
+
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	socket := &webSocket{conn: conn, reader: buffered.Reader, writer: buffered.Writer}
+	_, err = fmt.Fprintf(socket.writer, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+		base64.StdEncoding.EncodeToString(accept[:]))
+	if err == nil {
+		err = socket.writer.Flush()
+	}
+	if err != nil {
+		socket.close()
+		return nil, fmt.Errorf("error writing handshake: %w", err)
+	}
+	return socket, nil
+}
+
+// writeFrame writes an unfragmented frame, which servers send unmasked.
+func (socket *webSocket) writeFrame(opcode byte, payload []byte) error {
+	socket.mu.Lock()
+	defer socket.mu.Unlock()
+
+	header := []byte{0x80 | opcode}
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xffff:
+		header = append(header, 126, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+	default:
+		header = append(header, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+	}
+	_, err := socket.writer.Write(header)
+	if err == nil {
+		_, err = socket.writer.Write(payload)
+	}
+	if err == nil {
+		err = socket.writer.Flush()
+	}
+	return err
+}
+
+// writeJSON sends the value as a text message.
+func (socket *webSocket) writeJSON(value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return socket.writeFrame(webSocketText, payload)
+}
+
+// readFrame reads a frame sent by the client, which has to be masked.
+func (socket *webSocket) readFrame() (byte, []byte, error) {
+	var header [2]byte
+	_, err := io.ReadFull(socket.reader, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("unmasked client frame")
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		_, err = io.ReadFull(socket.reader, extended[:])
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		_, err = io.ReadFull(socket.reader, extended[:])
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if length > maxWebSocketFrame {
+		return 0, nil, errors.New("client frame too large")
+	}
+	var mask [4]byte
+	_, err = io.ReadFull(socket.reader, mask[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, length)
+	_, err = io.ReadFull(socket.reader, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return header[0] & 0x0f, payload, nil
+}
+
+// readControlFrames answers the pings of the client until it closes the
+// connection or the connection fails, then closes done.
+func (socket *webSocket) readControlFrames(done chan<- struct{}) {
+	defer close(done)
+	for {
+		opcode, payload, err := socket.readFrame()
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case webSocketPing:
+			err = socket.writeFrame(webSocketPong, payload)
+		case webSocketClose:
+			err = socket.writeFrame(webSocketClose, payload)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// closeMessage ends the connection with a close frame with normal closure.
+func (socket *webSocket) closeMessage() error {
+	return socket.writeFrame(webSocketClose, []byte{0x03, 0xe8})
+}
+
+func (socket *webSocket) close() {
+	err := socket.conn.Close()
+	if err != nil {
+		log.Printf("Error closing WebSocket: %v\n", err)
+	}
+}
+
+// serveJobWebSocket sends every event of the job as a JSON text message over
+// a WebSocket, starting with the past events, and closes the connection
+// once the job finished.
+func serveJobWebSocket(jobs *jobQueue, w http.ResponseWriter, r *http.Request, id string) {
+	if _, _, ok := jobs.get(id); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	socket, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("%v\n", err)
+		return
+	}
+	defer socket.close()
+
+	done := make(chan struct{})
+	go socket.readControlFrames(done)
+
+	sent := 0
+	for {
+		events, finished, changed, ok := jobs.events(id, sent)
+		if !ok {
+			break
+		}
+		for _, event := range events {
+			err = socket.writeJSON(event)
+			if err != nil {
+				log.Printf("Error writing: %v\n", err)
+				return
+			}
+			sent = event.ID
+		}
+		if finished {
+			break
+		}
+		select {
+		case <-changed:
+		case <-done:
+			return
+		}
+	}
+
+	err = socket.closeMessage()
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+		return
+	}
+	// Wait for the client to acknowledge the close, but not forever.
+	err = socket.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if err != nil {
+		log.Printf("Error setting deadline: %v\n", err)
+		return
+	}
+	<-done
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Multipart form fields of write requests holding the tag assignments or an
+// exported document, which are limited to maxTagsLength bytes.
+const (
+	tagsField     = "tags"
+	documentField = "json"
+	maxTagsLength = 1 << 20
+)
+
+var errNotWritten = errors.New("no file updated")
+
+// writeResultPattern matches the summaries exiftool prints of the created and
+// updated files and of those that already had the values.
+var writeResultPattern = regexp.MustCompile(`([1-9]\d*) image files? (?:created|updated|unchanged)`)
+
+// protectedTags are the pseudo tags that would rename, move or link the file
+// when written, and those geotagging it from a GPS log on the server, which
+// /metadata/geotag sets for the uploaded track only, in lower case.
+var protectedTags = map[string]bool{
+	"filename":  true,
+	"directory": true,
+	"hardlink":  true,
+	"symlink":   true,
+	"testname":  true,
+	"geotag":    true,
+	"geosync":   true,
+	"geotime":   true,
+}
+
+// assignmentArgs returns the exiftool arguments assigning the values to the
+// tags. A null value deletes the tag and an array assigns every item of a
+// list.
+func assignmentArgs(assignments map[string]interface{}) ([]string, error) {
+	tags := make([]string, 0, len(assignments))
+	for tag := range assignments {
+		if !tagNamePattern.MatchString(tag) || strings.ContainsAny(tag, "*?") {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		name := tag[strings.LastIndex(tag, ":")+1:]
+		if protectedTags[strings.ToLower(name)] {
+			return nil, fmt.Errorf("tag %q cannot be written", tag)
+		}
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	var args []string
+	for _, tag := range tags {
+		values, ok := assignments[tag].([]interface{})
+		if !ok {
+			values = []interface{}{assignments[tag]}
+		}
+		if len(values) == 0 {
+			args = append(args, "-"+tag+"=")
+		}
+		for _, value := range values {
+			switch v := value.(type) {
+			case nil:
+				args = append(args, "-"+tag+"=")
+			case string:
+				args = append(args, "-"+tag+"="+v)
+			case json.Number:
+				args = append(args, "-"+tag+"="+v.String())
+			case bool:
+				args = append(args, "-"+tag+"="+strconv.FormatBool(v))
+			default:
+				return nil, fmt.Errorf("invalid value of tag %q", tag)
+			}
+		}
+	}
+	return args, nil
+}
+
+// writeRequest is a multipart write request with the file and the values to
+// write, either the tag assignments of the tags field or the document of the
+// json field previously exported from /metadata, the arguments writing the
+// Stamp of the stamp field and the track of the track field to geotag the
+// file with.
+type writeRequest struct {
+	file        upload
+	assignments map[string]interface{}
+	document    Metadata
+	stamp       []string
+	track       upload
+}
+
+// remove removes the saved files of the request.
+func (request writeRequest) remove() {
+	for _, file := range []upload{request.file, request.track} {
+		if file.path != "" {
+			file.remove()
+		}
+	}
+}
+
+// decodeWriteField decodes the JSON value of a field of a write request.
+func decodeWriteField(part io.Reader, field string) (interface{}, error) {
+	decoder := json.NewDecoder(io.LimitReader(part, maxTagsLength))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s field: %w", field, err)
+	}
+	return value, nil
+}
+
+// saveWriteRequest saves the file of a multipart write request like
+// saveUpload, unless the file is not required and missing, and decodes the
+// values to write, which may be missing unless they are required.
+func saveWriteRequest(r *http.Request, fileRequired bool, valuesRequired bool) (writeRequest, error) {
+	var request writeRequest
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return request, err
+	}
+	var saved bool
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		var value interface{}
+		switch {
+		case err != nil:
+		case part.FormName() == uploadField && part.FileName() != "" && !saved:
+			request.file, err = saveFile(part, filepath.Base(part.FileName()))
+			saved = err == nil
+		case part.FormName() == trackField && part.FileName() != "" && request.track.path == "":
+			request.track, err = saveFile(part, filepath.Base(part.FileName()))
+		case part.FormName() == stampField:
+			request.stamp, err = decodeStamp(part)
+		case part.FormName() == tagsField:
+			value, err = decodeWriteField(part, tagsField)
+			if err == nil {
+				request.assignments, _ = value.(map[string]interface{})
+				if request.assignments == nil {
+					err = fmt.Errorf("invalid %s field: expected an object", tagsField)
+				}
+			}
+		case part.FormName() == documentField:
+			value, err = decodeWriteField(part, documentField)
+			if documents, ok := value.([]interface{}); ok && len(documents) > 0 {
+				// The array of the metadata of a single file.
+				value = documents[0]
+			}
+			if err == nil {
+				request.document, _ = value.(map[string]interface{})
+				if request.document == nil {
+					err = fmt.Errorf("invalid %s field: expected the metadata of a file", documentField)
+				}
+			}
+		}
+		if err != nil {
+			request.remove()
+			return writeRequest{}, err
+		}
+	}
+
+	switch {
+	case !saved && fileRequired:
+		err = errMissingUpload
+	case request.assignments == nil && request.document == nil && request.stamp == nil && request.track.path == "" && valuesRequired:
+		err = fmt.Errorf("missing %s, %s or %s field", tagsField, documentField, stampField)
+	case request.assignments != nil && request.document != nil:
+		err = fmt.Errorf("only one of the %s and %s fields can be given", tagsField, documentField)
+	}
+	if err != nil {
+		request.remove()
+		return writeRequest{}, err
+	}
+	return request, nil
+}
+
+// addedKeys are the keys the service adds to the extracted metadata, which
+// are no tags.
+var addedKeys = []string{checksumKey, regionsKey, computedKey, normalizedDatesKey, fingerprintKey, duplicateOfKey, burstKey}
+
+// saveImportDocument saves the metadata exported from /metadata for exiftool
+// -json= to apply it to the file written, leaving out the keys the service
+// added and the protected tags.
+func saveImportDocument(document Metadata) (upload, error) {
+	imported := make(Metadata, len(document))
+	for key, value := range document {
+		name := key[strings.LastIndex(key, ":")+1:]
+		if !protectedTags[strings.ToLower(name)] {
+			imported[key] = value
+		}
+	}
+	for _, key := range addedKeys {
+		delete(imported, key)
+	}
+	// The source file is matched against the file written, which has
+	// another name.
+	imported["SourceFile"] = "*"
+
+	content, err := json.Marshal([]Metadata{imported})
+	if err != nil {
+		return upload{}, err
+	}
+	return saveFile(bytes.NewReader(content), "metadata.json")
+}
+
+// writeOptions are the options of the write endpoints.
+type writeOptions struct {
+	// dryRun reports the changes a write would make instead of writing.
+	dryRun bool
+	// preserveTimes keeps the modification time of the file written, which
+	// matters for the files written in place.
+	preserveTimes bool
+	// keepOriginal keeps a copy of a file written in place with the suffix
+	// _original instead of overwriting it.
+	keepOriginal bool
+	// sidecar is the naming of the XMP sidecars written instead of the
+	// files, if not empty.
+	sidecar string
+}
+
+// newWriteOptions parses the query parameters common to the write endpoints.
+func newWriteOptions(query url.Values) (writeOptions, error) {
+	var options writeOptions
+	if value := getQueryParameter(query, "dryrun"); value != nil {
+		dryRun, err := strconv.ParseBool(*value)
+		if err != nil {
+			return writeOptions{}, fmt.Errorf("invalid dryrun parameter %q", *value)
+		}
+		options.dryRun = dryRun
+	}
+	if value := getQueryParameter(query, "preservetimes"); value != nil {
+		preserveTimes, err := strconv.ParseBool(*value)
+		if err != nil {
+			return writeOptions{}, fmt.Errorf("invalid preservetimes parameter %q", *value)
+		}
+		options.preserveTimes = preserveTimes
+	}
+	return options, nil
+}
+
+// args returns the exiftool arguments of the options, which precede the
+// values to write.
+func (options writeOptions) args() []string {
+	var args []string
+	if !options.keepOriginal {
+		args = append(args, "-overwrite_original")
+	}
+	if options.preserveTimes {
+		args = append(args, "-P")
+	}
+	return args
+}
+
+// writeMetadata runs exiftool with the arguments on the file, which include
+// the arguments of the writeOptions. It fails with errNotWritten and the messages of exiftool if the file
+// was neither updated nor had the values already, e.g. because a tag is
+// unknown or not writable.
+func writeMetadata(ctx context.Context, path string, args ...string) error {
+	args = append(append([]string{}, args...), path)
+	output, err := runExiftool(ctx, nil, args...)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errNotWritten, err)
+	}
+	if !writeResultPattern.Match(output) {
+		return fmt.Errorf("%w: %s", errNotWritten, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// serveFile writes the content of the file as an attachment with its name.
+func serveFile(w http.ResponseWriter, r *http.Request, file upload) {
+	content, err := os.Open(file.path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+	defer func() {
+		closeReader(content)
+	}()
+	info, err := content.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(content, head)
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.name}))
+	_, err = io.Copy(w, content)
+	if err != nil {
+		log.Printf("Error writing: %v\n", err)
+	}
+}
+
+// writeFile writes the file with the arguments and returns the modified file,
+// or, in a dry run, the changes of its metadata as a MetadataDiff.
+func writeFile(ctx context.Context, w http.ResponseWriter, r *http.Request, options writeOptions, file upload, args ...string) {
+	args = append(options.args(), args...)
+	commandCtx, cancel := commandContext(ctx, r)
+	defer cancel()
+	if options.dryRun {
+		diff, err := previewWrite(commandCtx, file, args...)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusUnprocessableEntity, err)
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		writeJSON(w, r, diff)
+		return
+	}
+
+	err := writeMetadata(commandCtx, file.path, args...)
+	if err != nil {
+		writeExtractionError(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+	serveFile(w, r, file)
+}
+
+// handleWriteMetadata writes tags to an uploaded file and returns the
+// modified file. The request is multipart form data with the file and either
+// a tags field holding a JSON object of the values to assign by tag name,
+// like {"Artist": "Jane Doe", "Keywords": ["a", "b"], "GPS:all": null}, or a
+// json field holding metadata exported from /metadata, which is applied with
+// exiftool -json=. A Stamp given with the stamp field writes the creator and
+// copyright in addition. A GPS track log uploaded with the track field, like
+// a GPX file, geotags the file with exiftool -geotag, which is required if
+// geotag is true, and the geosync parameter corrects the camera clock. With
+// dryrun=true, the changes are returned instead.
+func handleWriteMetadata(ctx context.Context, geotag bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			closeReader(r.Body)
+		}()
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		options, err := newWriteOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		geosync, err := getGeosync(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		request, err := saveWriteRequest(r, true, !geotag)
+		if err != nil {
+			writeExtractionError(w, r, http.StatusBadRequest, err)
+			return
+		}
+		defer request.remove()
+		if geotag && request.track.path == "" {
+			http.Error(w, fmt.Sprintf("missing %s file", trackField), http.StatusBadRequest)
+			return
+		}
+
+		var args []string
+		if request.document != nil {
+			document, err := saveImportDocument(request.document)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("%v\n", err)
+				return
+			}
+			defer document.remove()
+			args = []string{"-json=" + document.path}
+		} else {
+			args, err = assignmentArgs(request.assignments)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		args = append(args, request.stamp...)
+		if request.track.path != "" {
+			args = append(args, geotagArgs(request.track, geosync)...)
+		}
+		if len(args) == 0 {
+			http.Error(w, "no tags to write", http.StatusBadRequest)
+			return
+		}
+
+		writeFile(ctx, w, r, options, request.file, args...)
+	}
+}
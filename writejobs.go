@@ -0,0 +1,460 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Modes of write jobs on server-local files.
+const (
+	// writeInPlace modifies the files themselves.
+	writeInPlace = "inplace"
+	// writeCopies writes copies of the files to the output directory, which
+	// are served with the download links of the results.
+	writeCopies = "copy"
+)
+
+// WriteResult is the outcome of writing a file of a batch. In a dry run, no
+// file is written and Changes holds the changes the write would make.
+type WriteResult struct {
+	SourceFile string        `json:"sourceFile"`
+	Written    bool          `json:"written"`
+	Changes    *MetadataDiff `json:"changes,omitempty"`
+	Download   string        `json:"download,omitempty"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// writeFiles writes the tags to every file, or to its XMP sidecar if the
+// options say so, reporting the outcome of each file as the progress. If link is not nil, it returns the download link of
+// a written file. It only fails if the context is cancelled.
+func writeFiles(ctx context.Context, options writeOptions, files []upload, args []string, link func(upload) (string, error), progress func(JobProgress)) ([]WriteResult, error) {
+	args = append(options.args(), args...)
+	results := make([]WriteResult, 0, len(files))
+	for _, file := range files {
+		target, targetArgs := file, args
+		if options.sidecar != "" {
+			target = upload{name: sidecarName(file.name, options.sidecar), path: sidecarName(file.path, options.sidecar)}
+			targetArgs = sidecarArgs(file, args)
+		}
+		result := WriteResult{SourceFile: target.name, Written: !options.dryRun}
+		var err error
+		if options.dryRun {
+			var diff MetadataDiff
+			diff, err = previewWrite(ctx, target, targetArgs...)
+			result.Changes = &diff
+		} else {
+			err = writeMetadata(ctx, target.path, targetArgs...)
+			if err == nil && link != nil {
+				result.Download, err = link(target)
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			result.Written = false
+			result.Changes = nil
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+		progress(JobProgress{Processed: len(results), Total: len(files), Result: result})
+	}
+	return results, nil
+}
+
+// writeZip writes the files into a zip archive at the path, named by their
+// paths in the original archive.
+func writeZip(path string, files []upload) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	archive := zip.NewWriter(output)
+	for _, file := range files {
+		err = addZipEntry(archive, file)
+		if err != nil {
+			break
+		}
+	}
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing archive: %w", err)
+	}
+	return nil
+}
+
+func addZipEntry(archive *zip.Writer, file upload) error {
+	content, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeReader(content)
+	}()
+	entry, err := archive.Create(file.name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(entry, content)
+	return err
+}
+
+// archiveWriteJob returns a job writing the tags to every file of the
+// archive and packing the files, or only the sidecars written, into a new
+// archive at the output path, unless it is empty.
+func archiveWriteJob(archive upload, options writeOptions, args []string, output string) jobRunner {
+	return func(ctx context.Context, progress func(JobProgress)) (interface{}, error) {
+		dir, err := ioutil.TempDir("", "exiftool2json-")
+		if err != nil {
+			return nil, fmt.Errorf("error creating temporary directory: %w", err)
+		}
+		defer func() {
+			err := os.RemoveAll(dir)
+			if err != nil {
+				log.Printf("Error removing temporary directory: %v\n", err)
+			}
+		}()
+
+		files, err := extractArchive(archive, dir)
+		if errors.Is(err, errArchiveTooLarge) {
+			return nil, err
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", errInvalidArchive, err)
+		}
+		if options.sidecar != "" {
+			files = withoutSidecars(files)
+		}
+		results, err := writeFiles(ctx, options, files, args, nil, progress)
+		if err != nil || output == "" {
+			return results, err
+		}
+		if options.sidecar != "" {
+			files = writtenSidecars(files, results, options.sidecar)
+		}
+		err = writeZip(output, files)
+		if err != nil {
+			return nil, err
+		}
+		return results, nil
+	}
+}
+
+// directoryWriteJob returns a job writing the tags to the writable files
+// below the directory selected by the scan request, in place unless output
+// is not empty. Then the files are copied below the output directory of the
+// downloads first and linked under base.
+func directoryWriteJob(root string, request ScanRequest, options writeOptions, args []string, d *downloads, output string, base *url.URL) jobRunner {
+	return func(ctx context.Context, progress func(JobProgress)) (interface{}, error) {
+		var extensions map[string]bool
+		var err error
+		if options.sidecar != "" {
+			// The sidecars of the files are written, so the files
+			// themselves need not be writable.
+			extensions, err = recognizedExtensions(ctx)
+		} else {
+			extensions, err = writableExtensions(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		scanned, err := scanFiles(root, request, extensions)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", root, err)
+		}
+		files := make([]upload, 0, len(scanned))
+		for path, name := range scanned {
+			files = append(files, upload{name: name, path: path})
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return files[i].name < files[j].name
+		})
+		if options.sidecar != "" {
+			files = withoutSidecars(files)
+		}
+		if output == "" {
+			return writeFiles(ctx, options, files, args, nil, progress)
+		}
+
+		for i, file := range files {
+			relative, err := filepath.Rel(root, file.path)
+			if err != nil {
+				return nil, err
+			}
+			files[i].path, err = copyToDir(file.path, filepath.Join(output, relative))
+			if err != nil {
+				return nil, fmt.Errorf("error copying %s: %w", file.name, err)
+			}
+		}
+		return writeFiles(ctx, options, files, args, func(file upload) (string, error) {
+			return d.link(base, file.path)
+		}, progress)
+	}
+}
+
+// copyToDir copies the file to the path, creating its directory.
+func copyToDir(source string, path string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return "", err
+	}
+	content, err := os.Open(source)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		closeReader(content)
+	}()
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(file, content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return path, err
+}
+
+// createWriteJob enqueues writing the same tags to many files and responds
+// like createJob. The request is multipart form data like for POST
+// /metadata/write with either an archive, whose written files are served as
+// a zip archive from /jobs/{id}/archive, or without a file and the path
+// parameter naming a directory below the writable directories. The include
+// and exclude parameters select the files of the directory like for POST
+// /scan. By default or with mode=inplace, the files are written in place,
+// keeping their originals with the suffix _original if backup=true. With
+// mode=copy, copies of the files are written to the output directory and
+// served with the download links of the results. preservetimes=true keeps
+// the modification times of the files. With dryrun=true, no file is written
+// and the results hold the changes of every file instead. A stamp and a
+// track given like for POST /metadata/write are applied to every file, e.g.
+// to stamp the copyright of many files in one job.
+//
+// If sidecars is true, the metadata of every file is copied to its XMP
+// sidecar, created unless it exists, followed by the tags, which are
+// optional then, and the files themselves are left as they are. The naming
+// parameter selects whether the sidecar replaces the extension of the file
+// (replace, the default) or is appended to it (append). The sidecars of an
+// archive are served as a zip archive, and those of a directory are written
+// next to the files.
+func createWriteJob(jobs *jobQueue, writable *localFiles, d *downloads, sidecars bool, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	options, err := newWriteOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sidecars {
+		options.sidecar = sidecarReplace
+		if value := getQueryParameter(query, "naming"); value != nil {
+			options.sidecar = *value
+			if options.sidecar != sidecarReplace && options.sidecar != sidecarAppend {
+				http.Error(w, fmt.Sprintf("invalid naming parameter %q", *value), http.StatusBadRequest)
+				return
+			}
+		}
+		if options.dryRun {
+			http.Error(w, "sidecars cannot be written in a dry run", http.StatusBadRequest)
+			return
+		}
+	}
+	mode := writeInPlace
+	if value := getQueryParameter(query, "mode"); value != nil {
+		mode = *value
+		if mode != writeInPlace && mode != writeCopies {
+			http.Error(w, fmt.Sprintf("invalid mode parameter %q", *value), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := getQueryParameter(query, "backup"); value != nil {
+		backup, err := strconv.ParseBool(*value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid backup parameter %q", *value), http.StatusBadRequest)
+			return
+		}
+		options.keepOriginal = backup
+	}
+	callback, err := jobs.callback(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	geosync, err := getGeosync(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := getQueryParameter(query, "path")
+	switch {
+	case path == nil && (mode != writeInPlace || options.keepOriginal):
+		http.Error(w, "the mode and backup parameters require the path parameter", http.StatusBadRequest)
+		return
+	case mode == writeCopies && options.keepOriginal:
+		http.Error(w, "backup=true requires mode=inplace", http.StatusBadRequest)
+		return
+	case mode == writeCopies && d.dir == "":
+		http.Error(w, errDownloadsDisabled.Error(), http.StatusBadRequest)
+		return
+	}
+	// A dry run writes temporary copies, whose originals need not be kept.
+	options.keepOriginal = options.keepOriginal && !options.dryRun
+	scan := ScanRequest{Include: getQueryList(query, "include"), Exclude: getQueryList(query, "exclude")}
+	err = validatePatterns(scan.Include)
+	if err == nil {
+		err = validatePatterns(scan.Exclude)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	request, err := saveWriteRequest(r, path == nil, !sidecars)
+	if err != nil {
+		writeExtractionError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	cleanup := request.remove
+
+	var args []string
+	if request.document != nil {
+		document, err := saveImportDocument(request.document)
+		if err != nil {
+			cleanup()
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		cleanup = func() {
+			request.remove()
+			document.remove()
+		}
+		args = []string{"-json=" + document.path}
+	} else {
+		args, err = assignmentArgs(request.assignments)
+		if err != nil {
+			cleanup()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	args = append(args, request.stamp...)
+	if request.track.path != "" {
+		args = append(args, geotagArgs(request.track, geosync)...)
+	}
+	if len(args) == 0 && !sidecars {
+		cleanup()
+		http.Error(w, "no tags to write", http.StatusBadRequest)
+		return
+	}
+
+	var run jobRunner
+	var output string
+	switch {
+	case path != nil && request.file.path != "":
+		cleanup()
+		http.Error(w, "only one of a file and the path parameter can be given", http.StatusBadRequest)
+		return
+	case path != nil:
+		root, err := writable.resolveDir(*path)
+		switch {
+		case errors.Is(err, errPathNotAllowed):
+			cleanup()
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case os.IsNotExist(err):
+			cleanup()
+			http.NotFound(w, r)
+			return
+		case err != nil:
+			cleanup()
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scan.Path = *path
+		if mode == writeCopies && !options.dryRun {
+			output, err = d.createDir()
+			if err != nil {
+				cleanup()
+				w.WriteHeader(http.StatusInternalServerError)
+				log.Printf("%v\n", err)
+				return
+			}
+		}
+		run = directoryWriteJob(root, scan, options, args, d, output, requestBaseURL(r))
+	case options.dryRun:
+		run = archiveWriteJob(request.file, options, args, "")
+	default:
+		file, err := ioutil.TempFile("", "exiftool2json-*.zip")
+		if err == nil {
+			output = file.Name()
+			err = file.Close()
+		}
+		if err != nil {
+			cleanup()
+			removeJobOutput(output)
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%v\n", err)
+			return
+		}
+		run = archiveWriteJob(request.file, options, args, output)
+	}
+
+	created, err := jobs.enqueue(run, cleanup, callback, requestBaseURL(r), output)
+	switch {
+	case errors.Is(err, errJobQueueFull):
+		writeError(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("%v\n", err)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+created.ID)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, r, created)
+}
+
+// serveJobOutput serves the archive produced by a succeeded job. The files
+// written to the output directory are served from their download links
+// instead.
+func serveJobOutput(jobs *jobQueue, w http.ResponseWriter, r *http.Request, id string) {
+	status, output, ok := jobs.output(id)
+	if ok && status.Status == jobSucceeded {
+		info, err := os.Stat(output)
+		ok = err == nil && info.Mode().IsRegular()
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if status.Status != jobSucceeded {
+		writeError(w, r, http.StatusConflict, fmt.Sprintf("job is %s", status.Status))
+		return
+	}
+	serveFile(w, r, upload{name: id + ".zip", path: output})
+}